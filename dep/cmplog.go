@@ -0,0 +1,70 @@
+package dep
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+var cmpLog atomic.Value // *types.CmpLog, nil unless EnableCmpLog is called
+
+// EnableCmpLog records the latest size comparisons; they can be taken
+// by CmdGetCmpLog through the trace server
+func EnableCmpLog(size int) {
+	cmpLog.Store(types.NewCmpLog(size))
+}
+
+func getCmpLog() *types.CmpLog {
+	cl, _ := cmpLog.Load().(*types.CmpLog)
+	return cl
+}
+
+// TraceCmp is injected by the builder around comparisons with a literal:
+// `x == "abc"` ==> `func() bool { v := x; TraceCmp(id, "==", v, "abc"); return v == "abc" }() == true`
+func TraceCmp(id types.BlockIdType, op string, operand interface{}, expected string) {
+	cl := getCmpLog()
+	if cl == nil {
+		return
+	}
+	cl.Add(types.CmpRecord{Id: id, Op: op, Operand: formatOperand(operand), Expected: expected})
+}
+
+// `strings.EqualFold(a, b)` ==> `strings.EqualFold(TraceEqualFold(id, a, b))`
+func TraceEqualFold(id types.BlockIdType, a, b string) (string, string) {
+	if cl := getCmpLog(); cl != nil {
+		cl.Add(types.CmpRecord{Id: id, Op: "EqualFold", Operand: a, Expected: b})
+	}
+	return a, b
+}
+
+// `bytes.Equal(a, b)` ==> `bytes.Equal(TraceBytesEqual(id, a, b))`
+func TraceBytesEqual(id types.BlockIdType, a, b []byte) ([]byte, []byte) {
+	if cl := getCmpLog(); cl != nil {
+		cl.Add(types.CmpRecord{Id: id, Op: "Equal", Operand: string(a), Expected: string(b)})
+	}
+	return a, b
+}
+
+// format by the kind instead of fmt.Sprint, so named types implementing
+// fmt.Stringer are recorded with their values rather than their names
+func formatOperand(operand interface{}) string {
+	val := reflect.ValueOf(operand)
+	switch val.Kind() {
+	case reflect.String:
+		return val.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'g', -1, 64)
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			return string(val.Bytes())
+		}
+	}
+	return fmt.Sprintf("%v", operand)
+}
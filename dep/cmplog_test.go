@@ -0,0 +1,28 @@
+package dep
+
+import (
+	"testing"
+	"time"
+)
+
+type namedKind string
+
+func (k namedKind) String() string { return "kind" }
+
+func TestFormatOperand(t *testing.T) {
+	cases := map[string]interface{}{
+		"abc":     namedKind("abc"),
+		"-3":      int8(-3),
+		"255":     uint8(255),
+		"1.5":     1.5,
+		"bytes":   []byte("bytes"),
+		"<nil>":   nil,
+		"[1 2 3]": []int{1, 2, 3},
+		"-100":    time.Duration(-100),
+	}
+	for expect, operand := range cases {
+		if got := formatOperand(operand); got != expect {
+			t.Errorf("%#v: expect %s, got %s", operand, expect, got)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package dep
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyDumpSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			DumpCoverage()
+		}
+	}()
+}
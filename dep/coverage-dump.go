@@ -0,0 +1,73 @@
+package dep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	dumpPath    string
+	dumpBuildID string
+	dumpMu      sync.Mutex // only one dump is written at one time
+	dumpOnce    sync.Once
+)
+
+// EnableCoverageDump writes the coverage ever hit since start into path
+// on every DumpCoverage call, on SIGUSR1 and every interval seconds
+// if interval is positive. The builder injects DumpCoverage into exit paths
+func EnableCoverageDump(path, buildID string, interval int) {
+	dumpOnce.Do(func() {
+		dumpPath = path
+		dumpBuildID = buildID
+		atomic.StoreInt32(&coverageEnabled, 1)
+
+		notifyDumpSignal()
+
+		if interval <= 0 {
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(time.Duration(interval) * time.Second)
+			defer ticker.Stop()
+			for range ticker.C {
+				DumpCoverage()
+			}
+		}()
+	})
+}
+
+// DumpCoverage does nothing if EnableCoverageDump is not called
+func DumpCoverage() error {
+	dumpMu.Lock()
+	defer dumpMu.Unlock()
+	if dumpPath == "" {
+		return nil
+	}
+
+	// the counts not taken by the trace server yet are merged as well
+	coverage.Merge(GetTraceTable())
+
+	// write to a temp file then rename, so a crash while dumping
+	// won't destroy the last dump
+	f, err := ioutil.TempFile(filepath.Dir(dumpPath), filepath.Base(dumpPath)+".*")
+	if err != nil {
+		traceServerErrors.WithLabelValues("dump").Inc()
+		return err
+	}
+	err = coverage.WriteDump(f, dumpBuildID)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dumpPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		traceServerErrors.WithLabelValues("dump").Inc()
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package dep
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+func fetchRawBits(t *testing.T) {
+	var conn net.Conn
+	var err error
+	// the trace server is started in background
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", ListenAddress); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(types.Request{Cmd: types.CmdGetRawBits, Format: types.ReplyDense}.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := types.ReadReply(conn, types.ReplyDense); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bits fetched by the fuzzer are in dumps without metrics enabled
+func TestDumpFetchedCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coverage-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "coverage")
+
+	EnableCoverageDump(path, "build-1", 0)
+	Listen()
+	GetTraceTable().AddCount(1, 2)
+	fetchRawBits(t)
+	if err := DumpCoverage(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dump, err := types.ReadCoverageDump(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := types.NewCoverage()
+	if err := restored.Restore(dump.Bits); err != nil {
+		t.Fatal(err)
+	}
+	if restored.EverHit() != 1 {
+		t.Errorf("expect 1 edge in the dump, got %d", restored.EverHit())
+	}
+}
@@ -0,0 +1,4 @@
+package dep
+
+// there is no SIGUSR1 on windows
+func notifyDumpSignal() {}
@@ -0,0 +1,77 @@
+package dep
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+var (
+	crashPath     string
+	crashMu       sync.Mutex   // reports of goroutines exiting at the same time are not mixed
+	lastStatement atomic.Value // string
+)
+
+// EnableCrashReport appends a report to path before tidb exits by
+// os.Exit with non-zero code or by fatal logs; the builder injects
+// ReportExit and ReportFatal before these calls
+func EnableCrashReport(path string) {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	crashPath = path
+}
+
+// SetStatement is called by the trace server before the fuzzer executes a
+// statement, the latest one is written into crash reports
+func SetStatement(sql string) {
+	lastStatement.Store(sql)
+}
+
+func LastStatement() string {
+	sql, _ := lastStatement.Load().(string)
+	return sql
+}
+
+// `os.Exit(code)` ==> `os.Exit(ReportExit(id, code))`
+func ReportExit(id types.BlockIdType, code int) int {
+	if code != 0 {
+		writeCrashReport(id, fmt.Sprintf("os.Exit(%d)", code))
+	}
+	return code
+}
+
+// `log.Fatal(...)` ==> `ReportFatal(id, "log.Fatal"); log.Fatal(...)`
+func ReportFatal(id types.BlockIdType, call string) {
+	writeCrashReport(id, call)
+}
+
+func writeCrashReport(id types.BlockIdType, reason string) {
+	stack := debug.Stack()
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if crashPath == "" {
+		return
+	}
+	// the exit paths of tidb-server are skipped
+	DumpCoverage()
+
+	f, err := os.OpenFile(crashPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		traceServerErrors.WithLabelValues("crash").Inc()
+		return
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "=== %s exit by %s at site %d\nstatement: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339Nano), reason, id, LastStatement(), stack)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		traceServerErrors.WithLabelValues("crash").Inc()
+	}
+}
@@ -0,0 +1,45 @@
+package dep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrashReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crash.log")
+	EnableCrashReport(path)
+	defer EnableCrashReport("")
+
+	SetStatement("select 1")
+	if code := ReportExit(1, 0); code != 0 {
+		t.Errorf("unexpected code %d", code)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("orderly exit is reported: %v", err)
+	}
+
+	SetStatement("select * from t where a = 1")
+	if code := ReportExit(2, 1); code != 1 {
+		t.Errorf("unexpected code %d", code)
+	}
+	ReportFatal(3, "log.Fatal")
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(content)
+	for _, expect := range []string{"exit by os.Exit(1) at site 2", "exit by log.Fatal at site 3", "statement: select * from t where a = 1", "TestCrashReport"} {
+		if !strings.Contains(report, expect) {
+			t.Errorf("%q is not in report:\n%s", expect, report)
+		}
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/Illyrix/tidb-go-fuzz/dep
 
 go 1.13
+
+require github.com/prometheus/client_golang v1.5.1
@@ -0,0 +1,8 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package dep
+
+// getg identifies the running goroutine by the address of its runtime g
+// struct; implemented in goroutine_$GOARCH.s
+func getg() uintptr
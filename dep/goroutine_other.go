@@ -0,0 +1,24 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package dep
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// getg identifies the running goroutine by its id, which is parsed from
+// `goroutine 18 [running]:` at the head of the stack trace; it's much slower
+// than reading the g struct in goroutine_$GOARCH.s
+func getg() uintptr {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if idx := bytes.IndexByte(buf, ' '); idx >= 0 {
+		buf = buf[:idx]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return uintptr(id)
+}
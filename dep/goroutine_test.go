@@ -0,0 +1,17 @@
+package dep
+
+import "testing"
+
+func TestGetg(t *testing.T) {
+	self := getg()
+	if self == 0 || self != getg() {
+		t.Fatalf("unstable goroutine key %#x", self)
+	}
+	other := make(chan uintptr)
+	go func() {
+		other <- getg()
+	}()
+	if self == <-other {
+		t.Error("goroutines share the same key")
+	}
+}
@@ -0,0 +1,85 @@
+package dep
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const MetricsInterval = 15 * time.Second
+
+var (
+	coveredEdges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tidb",
+		Subsystem: "go_fuzz",
+		Name:      "covered_edges",
+		Help:      "Number of edges hit since tidb-server started.",
+	})
+	intervalEdges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tidb",
+		Subsystem: "go_fuzz",
+		Name:      "interval_edges",
+		Help:      "Number of edges hit in the last metrics interval.",
+	})
+	snapshotRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tidb",
+		Subsystem: "go_fuzz",
+		Name:      "snapshot_requests_total",
+		Help:      "Counter of trace bits snapshots requested from the trace server.",
+	})
+	recoveredPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tidb",
+		Subsystem: "go_fuzz",
+		Name:      "recovered_panics_total",
+		Help:      "Counter of panics recovered by instrumented recover calls.",
+	})
+	traceServerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tidb",
+		Subsystem: "go_fuzz",
+		Name:      "trace_server_errors_total",
+		Help:      "Counter of errors occurred in the trace server.",
+	}, []string{"type"})
+
+	metricsOnce sync.Once
+)
+
+// EnableMetrics registers the coverage metrics into the default prometheus
+// registry, so they are exposed with tidb's own metrics on its status port.
+// If addr is not empty, they are also served on `http://addr/metrics`
+func EnableMetrics(addr string) {
+	metricsOnce.Do(func() {
+		prometheus.MustRegister(coveredEdges, intervalEdges, snapshotRequests, recoveredPanics, traceServerErrors)
+		atomic.StoreInt32(&coverageEnabled, 1)
+
+		go func() {
+			ticker := time.NewTicker(MetricsInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				updateCoverageMetrics()
+			}
+		}()
+
+		if addr == "" {
+			return
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				traceServerErrors.WithLabelValues("metrics").Inc()
+			}
+		}()
+	})
+}
+
+// the counts not taken by the trace server yet are merged as well
+// but left in the trace table
+func updateCoverageMetrics() {
+	coverage.Merge(GetTraceTable())
+	coveredEdges.Set(float64(coverage.EverHit()))
+	intervalEdges.Set(float64(coverage.ResetInterval()))
+}
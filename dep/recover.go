@@ -0,0 +1,38 @@
+package dep
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+var panicLog atomic.Value // *types.PanicLog, nil unless EnableRecoverLog is called
+
+// EnableRecoverLog keeps the first size panics recovered by tidb between two
+// CmdGetPanics requests, so panics turned into SQL errors can be told from
+// ordinary errors of the statement
+func EnableRecoverLog(size int) {
+	panicLog.Store(types.NewPanicLog(size))
+}
+
+func getPanicLog() *types.PanicLog {
+	pl, _ := panicLog.Load().(*types.PanicLog)
+	return pl
+}
+
+// ReportRecover is injected around every recover:
+// `recover()` ==> `ReportRecover(id, recover())`
+// recover is still called by the deferred function directly, and the
+// panicking frames are still on the stack when it's called
+func ReportRecover(id types.BlockIdType, r interface{}) interface{} {
+	if r == nil {
+		return nil
+	}
+	recoveredPanics.Inc()
+	if pl := getPanicLog(); pl != nil {
+		pl.Add(types.PanicRecord{Id: id, Value: fmt.Sprint(r), Stack: string(debug.Stack())})
+	}
+	return r
+}
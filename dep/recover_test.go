@@ -0,0 +1,42 @@
+package dep
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+func panicInside() {
+	var m map[string]int
+	m["a"] = 1
+}
+
+func TestReportRecover(t *testing.T) {
+	EnableRecoverLog(4)
+	defer panicLog.Store((*types.PanicLog)(nil))
+
+	func() {
+		defer func() {
+			if r := ReportRecover(1, recover()); r == nil {
+				t.Error("panic is not recovered")
+			}
+		}()
+		panicInside()
+	}()
+	if r := ReportRecover(2, nil); r != nil {
+		t.Errorf("unexpected %v", r)
+	}
+
+	records, total := getPanicLog().Take()
+	if total != 1 || len(records) != 1 || records[0].Id != 1 {
+		t.Fatalf("unexpected records %v, total %d", records, total)
+	}
+	if !strings.Contains(records[0].Value, "nil map") {
+		t.Errorf("unexpected value %s", records[0].Value)
+	}
+	// the stack is taken before unwinding
+	if !strings.Contains(records[0].Stack, "panicInside") {
+		t.Errorf("panicking function is not in stack:\n%s", records[0].Stack)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Illyrix/tidb-go-fuzz/dep/types"
 )
@@ -11,6 +12,11 @@ import (
 var (
 	traceTable *types.TraceBits
 	mu         sync.Mutex // this lock is just for singleton
+
+	// every snapshot taken by the trace server is merged into it when
+	// metrics or coverage dumps are enabled
+	coverage        = types.NewCoverage()
+	coverageEnabled int32 // 1 after EnableMetrics or EnableCoverageDump
 )
 
 const ListenAddress = "127.0.0.1:16801"
@@ -31,23 +37,106 @@ func GetTraceTable() *types.TraceBits {
 	return traceTable
 }
 
+// AddBlock is injected into every block in dynamic edge mode, instead of
+// `GetTraceTable().AddCount(src, dst)` with static edges
+func AddBlock(id types.BlockIdType) {
+	GetTraceTable().AddBlock(getg(), id)
+}
+
+// AddCount is injected instead of `GetTraceTable().AddCount(src, dst)`
+// when call context is enabled, since the context is per goroutine
+func AddCount(src, dst types.BlockIdType) {
+	GetTraceTable().AddCountIn(getg(), src, dst)
+}
+
+// EnableCallContext mixes the innermost depth functions into edges
+func EnableCallContext(depth int) {
+	GetTraceTable().EnableCallContext(depth)
+}
+
+// EnterFunc is injected at the beginning of functions along with
+// `defer ExitFunc()` when call context is enabled
+func EnterFunc(id types.BlockIdType) {
+	GetTraceTable().EnterFunc(getg(), id)
+}
+
+func ExitFunc() {
+	GetTraceTable().ExitFunc(getg())
+}
+
+// EnableTraceRoute records the latest size blocks executed by every
+// goroutine in order, the route of the session running the statement can be
+// taken by CmdGetRoute through the trace server. The builder injects AddCount
+// instead of `GetTraceTable().AddCount(src, dst)`, so goroutines are known
+func EnableTraceRoute(size int) {
+	GetTraceTable().EnableRoute(size)
+}
+
 // start listening in init()
 func Listen() {
 	handler := func(c *net.TCPConn) {
-		data := make([]byte, 255)
-		_, err := c.Read(data) // todo: support distinguish SQL trace log
+		defer c.Close()
+		req, err := types.ReadRequest(c) // todo: support distinguish SQL trace log
 		if err != nil {
-			panic(err) // todo: debug
+			traceServerErrors.WithLabelValues("read").Inc()
+			return
 		}
 
-		tb := GetTraceTable()
-		tb.ClassifyCount()
-		_, err = c.Write(tb.GetBits())
-		if err != nil {
-			panic(err) // todo: debug
+		var reply []byte
+		switch req.Cmd {
+		case types.CmdGetBits, types.CmdGetRawBits:
+			// unknown formats are not supported by this server, drop the client
+			// before the counts are taken away
+			if !types.ValidFormat(req.Format) {
+				traceServerErrors.WithLabelValues("encode").Inc()
+				return
+			}
+			snapshotRequests.Inc()
+			snapshot := GetTraceTable().Snapshot()
+			if atomic.LoadInt32(&coverageEnabled) == 1 {
+				coverage.Merge(snapshot)
+			}
+			if req.Cmd == types.CmdGetBits {
+				snapshot = snapshot.Classified()
+			}
+			reply, err = snapshot.Encode(req.Format)
+			if err != nil {
+				traceServerErrors.WithLabelValues("encode").Inc()
+				return
+			}
+		case types.CmdGetRoute:
+			reply = types.EncodeRoute(GetTraceTable().TakeRoute())
+		case types.CmdGetCmpLog:
+			var records []types.CmpRecord
+			var total uint64
+			if cl := getCmpLog(); cl != nil {
+				records, total = cl.Take()
+			}
+			reply = types.EncodeCmpLog(records, total)
+		case types.CmdSetStatement:
+			sql, err := types.ReadStatement(c)
+			if err != nil {
+				traceServerErrors.WithLabelValues("read").Inc()
+				return
+			}
+			SetStatement(sql)
+			reply = []byte{types.StatementAck}
+		case types.CmdGetPanics:
+			var records []types.PanicRecord
+			var total uint64
+			if pl := getPanicLog(); pl != nil {
+				records, total = pl.Take()
+			}
+			reply = types.EncodePanics(records, total)
+		default:
+			traceServerErrors.WithLabelValues("command").Inc()
+			return
 		}
 
-		tb.Clean()
+		_, err = c.Write(reply)
+		if err != nil {
+			traceServerErrors.WithLabelValues("write").Inc()
+		}
 	}
 
 	go func() {
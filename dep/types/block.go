@@ -1,11 +1,11 @@
 package types
 
-/*
 type CharPosition struct {
 	Line   uint32
 	Column uint32
 }
 
+/*
 type BlockType uint8
 
 const (
@@ -16,12 +16,15 @@ const (
 	IfStmt     BlockType = iota
 	// todo more stmts
 )
+*/
 
+// Block is where a counter is injected; the builder writes all of them
+// into a block map so trace routes can be mapped back to source code
 type Block struct {
 	Id    BlockIdType
 	File  string
 	Start CharPosition
 	End   CharPosition
-	Type  BlockType
+	Func  string // the function entered, if Id is of EnterFunc rather than a counter
+	// Type  BlockType
 }
-*/
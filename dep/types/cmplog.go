@@ -0,0 +1,141 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// operands longer than it are truncated
+const MaxCmpOperandSize = 256
+
+// CmpRecord is one comparison executed by the instrumented code; Expected
+// is the literal at build time, or the other operand for calls like
+// `strings.EqualFold(a, b)`
+type CmpRecord struct {
+	Id       BlockIdType
+	Op       string // `==`, `<`, `EqualFold` ...
+	Operand  string
+	Expected string
+}
+
+// CmpLog is a ring buffer keeping the latest comparisons
+type CmpLog struct {
+	records []CmpRecord
+	total   uint64 // how many records are added since last Take
+	mu      sync.Mutex
+}
+
+func NewCmpLog(size int) *CmpLog {
+	if size <= 0 {
+		panic("size of CmpLog should be positive")
+	}
+	return &CmpLog{records: make([]CmpRecord, size)}
+}
+
+func (cl *CmpLog) Add(r CmpRecord) {
+	if len(r.Operand) > MaxCmpOperandSize {
+		r.Operand = r.Operand[:MaxCmpOperandSize]
+	}
+	if len(r.Expected) > MaxCmpOperandSize {
+		r.Expected = r.Expected[:MaxCmpOperandSize]
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.records[cl.total%uint64(len(cl.records))] = r
+	cl.total++
+}
+
+// Take returns the records from the oldest to the newest with the number
+// of records added, then starts a new log
+func (cl *CmpLog) Take() ([]CmpRecord, uint64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	size := uint64(len(cl.records))
+	total := cl.total
+	var res []CmpRecord
+	if total <= size {
+		res = append(res, cl.records[:total]...)
+	} else {
+		start := total % size
+		res = append(res, cl.records[start:]...)
+		res = append(res, cl.records[:start]...)
+	}
+	cl.total = 0
+	return res, total
+}
+
+// cmp log reply: uint64 total, uint32 count, then count * record;
+// a record is uint16 id followed by op, operand and expected,
+// each of them is uvarint(length) and bytes
+func EncodeCmpLog(records []CmpRecord, total uint64) []byte {
+	res := make([]byte, 12)
+	binary.BigEndian.PutUint64(res, total)
+	binary.BigEndian.PutUint32(res[8:], uint32(len(records)))
+	for _, r := range records {
+		res = append(res, byte(r.Id>>8), byte(r.Id))
+		for _, str := range []string{r.Op, r.Operand, r.Expected} {
+			res = appendUvarint(res, uint64(len(str)))
+			res = append(res, str...)
+		}
+	}
+	return res
+}
+
+func ReadCmpLog(r io.Reader) ([]CmpRecord, uint64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	total := binary.BigEndian.Uint64(header)
+	count := binary.BigEndian.Uint32(header[8:])
+	if uint64(count) > total {
+		return nil, 0, errors.New("cmp log reply: more records than total")
+	}
+
+	br := &byteReader{r: r}
+	res := make([]CmpRecord, 0, count)
+	for i := uint32(0); i < count; i++ {
+		id := make([]byte, 2)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return nil, 0, err
+		}
+		var fields [3]string
+		for j := range fields {
+			size, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, 0, err
+			}
+			if size > MaxCmpOperandSize {
+				return nil, 0, errors.New("cmp log reply: operand too long")
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, 0, err
+			}
+			fields[j] = string(buf)
+		}
+		res = append(res, CmpRecord{
+			Id:       binary.BigEndian.Uint16(id),
+			Op:       fields[0],
+			Operand:  fields[1],
+			Expected: fields[2],
+		})
+	}
+	return res, total, nil
+}
+
+// byteReader reads one byte at a time, so nothing after a uvarint is
+// consumed from the underlying reader
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(br.r, br.buf[:]); err != nil {
+		return 0, err
+	}
+	return br.buf[0], nil
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCmpLog(t *testing.T) {
+	cl := NewCmpLog(2)
+	cl.Add(CmpRecord{Id: 1, Op: "==", Operand: "a", Expected: "b"})
+	cl.Add(CmpRecord{Id: 2, Op: "<", Operand: "3", Expected: "10"})
+	cl.Add(CmpRecord{Id: 3, Op: "EqualFold", Operand: strings.Repeat("x", 1000), Expected: "tidb_mem_quota_query"})
+
+	records, total := cl.Take()
+	if total != 3 || len(records) != 2 || records[0].Id != 2 || records[1].Id != 3 {
+		t.Fatalf("unexpected records %v, total %d", records, total)
+	}
+	if len(records[1].Operand) != MaxCmpOperandSize {
+		t.Errorf("operand is not truncated: %d bytes", len(records[1].Operand))
+	}
+
+	got, gotTotal, err := ReadCmpLog(bytes.NewReader(EncodeCmpLog(records, total)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) || gotTotal != total {
+		t.Errorf("decoded records %v, total %d", got, gotTotal)
+	}
+
+	if records, total = cl.Take(); len(records) != 0 || total != 0 {
+		t.Errorf("log is not cleaned: %v", records)
+	}
+}
@@ -0,0 +1,84 @@
+package types
+
+// callContext is the stack of functions entered by one goroutine, with
+// the hash of the innermost `depth` functions at every frame
+type callContext struct {
+	funcs  []BlockIdType
+	hashes []BlockIdType
+}
+
+// EnableCallContext mixes the hash of the innermost depth functions of the
+// calling goroutine into every edge key, so reaching a familiar edge from
+// new callers is new coverage. Functions are tracked by EnterFunc/ExitFunc
+func (tb *TraceBits) EnableCallContext(depth int) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.contextDepth = depth
+	tb.contexts = make(map[uintptr]*callContext)
+}
+
+func (tb *TraceBits) EnterFunc(goroutine uintptr, id BlockIdType) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.contextDepth <= 0 {
+		return
+	}
+
+	ctx, ok := tb.contexts[goroutine]
+	if !ok {
+		ctx = &callContext{}
+		tb.contexts[goroutine] = ctx
+	}
+	ctx.funcs = append(ctx.funcs, id)
+	// rotate so that the order of callers matters
+	hash := BlockIdType(0)
+	for i := len(ctx.funcs) - 1; i >= 0 && i >= len(ctx.funcs)-tb.contextDepth; i-- {
+		hash = (hash<<3 | hash>>13) ^ ctx.funcs[i]
+	}
+	ctx.hashes = append(ctx.hashes, hash)
+}
+
+// ExitFunc is deferred right after EnterFunc, so it runs on panics as well
+func (tb *TraceBits) ExitFunc(goroutine uintptr) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	ctx, ok := tb.contexts[goroutine]
+	if !ok {
+		return
+	}
+	ctx.funcs = ctx.funcs[:len(ctx.funcs)-1]
+	ctx.hashes = ctx.hashes[:len(ctx.hashes)-1]
+	if len(ctx.funcs) == 0 {
+		delete(tb.contexts, goroutine)
+	}
+}
+
+// AddCountIn is AddCount mixed with the call context of the goroutine
+func (tb *TraceBits) AddCountIn(goroutine uintptr, src, dst BlockIdType) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.addKey(goroutine, (src<<1)^dst^tb.contextHash(goroutine), dst)
+}
+
+// NOTE: tb.mu must be held
+func (tb *TraceBits) contextHash(goroutine uintptr) BlockIdType {
+	ctx, ok := tb.contexts[goroutine]
+	if !ok {
+		return 0
+	}
+	return ctx.hashes[len(ctx.hashes)-1]
+}
@@ -0,0 +1,42 @@
+package types
+
+import "testing"
+
+func TestCallContext(t *testing.T) {
+	tb := NewTraceBits()
+	tb.EnableCallContext(2)
+
+	// the same edge in helper 3 reached from caller 1 and caller 2
+	for _, caller := range []BlockIdType{1, 2} {
+		tb.EnterFunc(7, caller)
+		tb.EnterFunc(7, 3)
+		tb.AddCountIn(7, 10, 11)
+		tb.ExitFunc(7)
+		tb.ExitFunc(7)
+	}
+	if n := countNonZero(tb.GetBits()); n != 2 {
+		t.Errorf("expect 2 edges with different contexts, got %d", n)
+	}
+
+	// contexts beyond depth don't matter
+	tb.Clean()
+	for _, root := range []BlockIdType{4, 5} {
+		tb.EnterFunc(7, root)
+		tb.EnterFunc(7, 1)
+		tb.EnterFunc(7, 3)
+		tb.AddCountIn(7, 10, 11)
+		tb.ExitFunc(7)
+		tb.ExitFunc(7)
+		tb.ExitFunc(7)
+	}
+	if n := countNonZero(tb.GetBits()); n != 1 {
+		t.Errorf("expect 1 edge within depth 2, got %d", n)
+	}
+
+	// no context left after all functions exit
+	tb.Clean()
+	tb.AddCountIn(7, 10, 11)
+	if count, _ := tb.GetCount(10<<1, 11); count != 1 {
+		t.Errorf("expect edge without context, got count %d", count)
+	}
+}
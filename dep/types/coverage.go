@@ -0,0 +1,163 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// magic at the head of coverage dump files
+const CoverageDumpMagic = "TGFCOV01"
+
+// CoverageDump is the content of a coverage dump file
+type CoverageDump struct {
+	BuildID   string
+	MapSize   uint64
+	Timestamp time.Time
+	Bits      []byte // classified buckets ever hit
+}
+
+// Coverage accumulates every snapshot taken from a TraceBits, it remembers
+// which buckets of which edges have ever been hit since start
+type Coverage struct {
+	ever     [TraceBitsSize]byte // OR of classified buckets since start
+	interval [TraceBitsSize]byte // OR of classified buckets since last Reset
+	mu       sync.Mutex
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// Merge raw trace bits into the coverage; it returns how many edges are
+// hit for the first time
+func (c *Coverage) Merge(tb *TraceBits) (int, error) {
+	newEdges, _, err := c.MergeBuckets(tb)
+	return newEdges, err
+}
+
+// MergeBuckets is like Merge, it also returns how many edges already hit
+// are hit with new hit-count buckets, like AFL does
+func (c *Coverage) MergeBuckets(tb *TraceBits) (int, int, error) {
+	if c == nil || tb == nil {
+		return 0, 0, errors.New("Coverage has not been initialized")
+	}
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	newEdges, newBuckets := 0, 0
+	for key, val := range tb.bits {
+		if val == 0 {
+			continue
+		}
+		bucket := classify(val)
+		if c.ever[key] == 0 {
+			newEdges++
+		} else if c.ever[key]&bucket == 0 {
+			newBuckets++
+		}
+		c.ever[key] |= bucket
+		c.interval[key] |= bucket
+	}
+	return newEdges, newBuckets, nil
+}
+
+// Restore replaces the ever hit buckets with bits of a coverage dump, so
+// a resumed campaign doesn't count them as new again
+func (c *Coverage) Restore(bits []byte) error {
+	if uint64(len(bits)) != TraceBitsSize {
+		return fmt.Errorf("map size %d mismatches %d", len(bits), TraceBitsSize)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	copy(c.ever[:], bits)
+	return nil
+}
+
+// EverHit returns how many edges have been hit since start
+func (c *Coverage) EverHit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return countNonZero(c.ever[:])
+}
+
+// ResetInterval returns how many edges have been hit since last call
+// and starts a new interval
+func (c *Coverage) ResetInterval() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := countNonZero(c.interval[:])
+	c.interval = [TraceBitsSize]byte{0}
+	return res
+}
+
+func countNonZero(bits []byte) int {
+	res := 0
+	for _, val := range bits {
+		if val != 0 {
+			res++
+		}
+	}
+	return res
+}
+
+// WriteDump writes the ever hit buckets with a header of
+// magic, build id, map size and timestamp
+func (c *Coverage) WriteDump(w io.Writer, buildID string) error {
+	if len(buildID) > 0xffff {
+		return errors.New("build id is too long")
+	}
+	c.mu.Lock()
+	bits := c.ever
+	c.mu.Unlock()
+
+	buf := bytes.NewBufferString(CoverageDumpMagic)
+	binary.Write(buf, binary.BigEndian, uint16(len(buildID)))
+	buf.WriteString(buildID)
+	binary.Write(buf, binary.BigEndian, TraceBitsSize)
+	binary.Write(buf, binary.BigEndian, time.Now().UnixNano())
+	buf.Write(bits[:])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func ReadCoverageDump(r io.Reader) (*CoverageDump, error) {
+	magic := make([]byte, len(CoverageDumpMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != CoverageDumpMagic {
+		return nil, errors.New("not a coverage dump file")
+	}
+	var idLen uint16
+	if err := binary.Read(r, binary.BigEndian, &idLen); err != nil {
+		return nil, err
+	}
+	id := make([]byte, idLen)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, err
+	}
+	res := &CoverageDump{BuildID: string(id)}
+	if err := binary.Read(r, binary.BigEndian, &res.MapSize); err != nil {
+		return nil, err
+	}
+	if res.MapSize != TraceBitsSize {
+		return nil, fmt.Errorf("map size %d mismatches %d", res.MapSize, TraceBitsSize)
+	}
+	var nano int64
+	if err := binary.Read(r, binary.BigEndian, &nano); err != nil {
+		return nil, err
+	}
+	res.Timestamp = time.Unix(0, nano)
+	res.Bits = make([]byte, res.MapSize)
+	if _, err := io.ReadFull(r, res.Bits); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCoverageMerge(t *testing.T) {
+	c := NewCoverage()
+	tb := NewTraceBits()
+	tb.AddCount(0, 1)
+	tb.AddCount(0, 2)
+
+	if n, _ := c.Merge(tb); n != 2 {
+		t.Errorf("expect 2 new edges, got %d", n)
+	}
+	if n, _ := c.Merge(tb); n != 0 {
+		t.Errorf("expect no new edges, got %d", n)
+	}
+	if n := c.ResetInterval(); n != 2 {
+		t.Errorf("expect 2 edges in interval, got %d", n)
+	}
+	if n := c.ResetInterval(); n != 0 {
+		t.Errorf("expect empty interval, got %d", n)
+	}
+	if n := c.EverHit(); n != 2 {
+		t.Errorf("expect 2 edges ever hit, got %d", n)
+	}
+
+	// hit 3 times, which is in a new bucket
+	tb.AddCount(0, 1)
+	tb.AddCount(0, 1)
+	if edges, buckets, _ := c.MergeBuckets(tb); edges != 0 || buckets != 1 {
+		t.Errorf("expect 1 new bucket, got %d edges and %d buckets", edges, buckets)
+	}
+	if edges, buckets, _ := c.MergeBuckets(tb); edges != 0 || buckets != 0 {
+		t.Errorf("expect nothing new, got %d edges and %d buckets", edges, buckets)
+	}
+}
+
+func TestCoverageDump(t *testing.T) {
+	c := NewCoverage()
+	tb := NewTraceBits()
+	for i := 0; i < 5; i++ {
+		tb.AddCount(3, 4)
+	}
+	c.Merge(tb)
+
+	buf := new(bytes.Buffer)
+	if err := c.WriteDump(buf, "build-1"); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := ReadCoverageDump(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.BuildID != "build-1" || dump.MapSize != TraceBitsSize {
+		t.Errorf("bad header %s %d", dump.BuildID, dump.MapSize)
+	}
+	if dump.Bits[(3<<1)^4] != 8 {
+		t.Errorf("expect bucket 8, got %d", dump.Bits[(3<<1)^4])
+	}
+
+	restored := NewCoverage()
+	if err := restored.Restore(dump.Bits); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := restored.Merge(tb); n != 0 || restored.EverHit() != 1 {
+		t.Errorf("restored coverage should have the edge, got %d new, %d hit", n, restored.EverHit())
+	}
+
+	if _, err := ReadCoverageDump(bytes.NewBufferString("not a dump")); err == nil {
+		t.Error("bad magic should fail")
+	}
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// panic values and stacks longer than it are truncated
+const MaxPanicStackSize = 16 * 1024
+
+// PanicRecord is a panic recovered by the instrumented code, Id tells
+// which `recover()` recovers it
+type PanicRecord struct {
+	Id    BlockIdType
+	Value string
+	Stack string // stack of the panicking goroutine when it's recovered
+}
+
+// PanicLog keeps the first recovered panics since last Take; unlike the
+// cmp log, the earliest panic is usually the cause of the others
+type PanicLog struct {
+	records []PanicRecord
+	size    int
+	total   uint64 // how many panics are recovered since last Take
+	mu      sync.Mutex
+}
+
+func NewPanicLog(size int) *PanicLog {
+	if size <= 0 {
+		panic("size of PanicLog should be positive")
+	}
+	return &PanicLog{size: size}
+}
+
+func (pl *PanicLog) Add(r PanicRecord) {
+	if len(r.Value) > MaxPanicStackSize {
+		r.Value = r.Value[:MaxPanicStackSize]
+	}
+	if len(r.Stack) > MaxPanicStackSize {
+		r.Stack = r.Stack[:MaxPanicStackSize]
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if len(pl.records) < pl.size {
+		pl.records = append(pl.records, r)
+	}
+	pl.total++
+}
+
+// Take returns the kept records with the number of panics recovered,
+// then starts a new log
+func (pl *PanicLog) Take() ([]PanicRecord, uint64) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	res, total := pl.records, pl.total
+	pl.records, pl.total = nil, 0
+	return res, total
+}
+
+// panic reply: uint64 total, uint32 count, then count * record;
+// a record is uint16 id followed by value and stack, each of them is
+// uvarint(length) and bytes
+func EncodePanics(records []PanicRecord, total uint64) []byte {
+	res := make([]byte, 12)
+	binary.BigEndian.PutUint64(res, total)
+	binary.BigEndian.PutUint32(res[8:], uint32(len(records)))
+	for _, r := range records {
+		res = append(res, byte(r.Id>>8), byte(r.Id))
+		for _, str := range []string{r.Value, r.Stack} {
+			res = appendUvarint(res, uint64(len(str)))
+			res = append(res, str...)
+		}
+	}
+	return res
+}
+
+func ReadPanics(r io.Reader) ([]PanicRecord, uint64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	total := binary.BigEndian.Uint64(header)
+	count := binary.BigEndian.Uint32(header[8:])
+	if uint64(count) > total {
+		return nil, 0, errors.New("panic reply: more records than total")
+	}
+
+	br := &byteReader{r: r}
+	res := make([]PanicRecord, 0, count)
+	for i := uint32(0); i < count; i++ {
+		id := make([]byte, 2)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return nil, 0, err
+		}
+		var fields [2]string
+		for j := range fields {
+			size, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, 0, err
+			}
+			if size > MaxPanicStackSize {
+				return nil, 0, errors.New("panic reply: field too long")
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, 0, err
+			}
+			fields[j] = string(buf)
+		}
+		res = append(res, PanicRecord{
+			Id:    binary.BigEndian.Uint16(id),
+			Value: fields[0],
+			Stack: fields[1],
+		})
+	}
+	return res, total, nil
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPanicLog(t *testing.T) {
+	pl := NewPanicLog(2)
+	pl.Add(PanicRecord{Id: 1, Value: "index out of range", Stack: "goroutine 1 [running]:"})
+	pl.Add(PanicRecord{Id: 2, Value: "nil pointer", Stack: strings.Repeat("x", 2*MaxPanicStackSize)})
+	pl.Add(PanicRecord{Id: 3, Value: "dropped"})
+
+	// the first ones are kept
+	records, total := pl.Take()
+	if total != 3 || len(records) != 2 || records[0].Id != 1 || records[1].Id != 2 {
+		t.Fatalf("unexpected records %v, total %d", records, total)
+	}
+	if len(records[1].Stack) != MaxPanicStackSize {
+		t.Errorf("stack is not truncated: %d bytes", len(records[1].Stack))
+	}
+
+	got, gotTotal, err := ReadPanics(bytes.NewReader(EncodePanics(records, total)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) || gotTotal != total {
+		t.Errorf("decoded records %v, total %d", got, gotTotal)
+	}
+
+	if records, total = pl.Take(); len(records) != 0 || total != 0 {
+		t.Errorf("log is not cleaned: %v", records)
+	}
+}
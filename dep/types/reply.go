@@ -0,0 +1,228 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Command is the first byte of every request sent to the trace server
+type Command = byte
+
+const (
+	CmdGetBits      Command = iota // reply the classified trace bits and clean them
+	CmdGetRawBits                  // reply the raw hit counts and clean them
+	CmdGetRoute                    // reply the recorded route and clean it
+	CmdGetCmpLog                   // reply the recorded comparisons and clean them
+	CmdGetPanics                   // reply the recovered panics and clean them
+	CmdSetStatement                // followed by a statement; reply one byte after it's set
+)
+
+// ReplyFormat is the second byte of a request; it tells the server
+// how to encode the trace bits in its reply
+type ReplyFormat = byte
+
+const (
+	ReplyDense  ReplyFormat = iota // raw TraceBitsSize bytes without header
+	ReplySparse                    // only non-zero (index, bucket) pairs
+	ReplyRLE                       // runs of zero buckets and literal buckets
+)
+
+const RequestSize = 2
+
+// ValidFormat tells if the trace bits can be encoded in format
+func ValidFormat(format ReplyFormat) bool {
+	return format <= ReplyRLE
+}
+
+// header of non-dense replies: 1 byte format + 4 bytes payload length
+const replyHeaderSize = 5
+
+// neither sparse nor rle spends more than two uvarints and one byte on every
+// bucket, so longer payloads are rejected before being allocated
+const maxReplyPayload = binary.MaxVarintLen32 + TraceBitsSize*(2*binary.MaxVarintLen32+1)
+
+type Request struct {
+	Cmd    Command
+	Format ReplyFormat
+}
+
+func (r Request) Bytes() []byte {
+	return []byte{r.Cmd, r.Format}
+}
+
+func ReadRequest(r io.Reader) (Request, error) {
+	buf := make([]byte, RequestSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Request{}, err
+	}
+	return Request{Cmd: buf[0], Format: buf[1]}, nil
+}
+
+// Encode the trace bits into a reply in the given format
+func (tb *TraceBits) Encode(format ReplyFormat) ([]byte, error) {
+	if tb == nil {
+		return nil, errors.New("TraceBits has not been initialized")
+	}
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+
+	var payload []byte
+	switch format {
+	case ReplyDense:
+		res := make([]byte, TraceBitsSize)
+		copy(res, tb.bits[:])
+		return res, nil
+	case ReplySparse:
+		payload = encodeSparse(tb.bits[:])
+	case ReplyRLE:
+		payload = encodeRLE(tb.bits[:])
+	default:
+		return nil, fmt.Errorf("unknown reply format %d", format)
+	}
+
+	res := make([]byte, replyHeaderSize, replyHeaderSize+len(payload))
+	res[0] = format
+	binary.BigEndian.PutUint32(res[1:], uint32(len(payload)))
+	return append(res, payload...), nil
+}
+
+// ReadReply reads one reply in the given format from r and reconstructs
+// the dense trace bits, so callers don't care about which format is used
+func ReadReply(r io.Reader, format ReplyFormat) (*TraceBits, error) {
+	tb := NewTraceBits()
+	if format == ReplyDense {
+		if _, err := io.ReadFull(r, tb.bits[:]); err != nil {
+			return nil, err
+		}
+		return tb, nil
+	}
+
+	header := make([]byte, replyHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != format {
+		return nil, fmt.Errorf("reply format %d mismatches requested %d", header[0], format)
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if uint64(size) > maxReplyPayload {
+		return nil, fmt.Errorf("reply payload of %d bytes is too long", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch format {
+	case ReplySparse:
+		err = decodeSparse(payload, tb.bits[:])
+	case ReplyRLE:
+		err = decodeRLE(payload, tb.bits[:])
+	default:
+		err = fmt.Errorf("unknown reply format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tb, nil
+}
+
+// uvarint(count) followed by count * (uvarint(index delta), bucket)
+func encodeSparse(bits []byte) []byte {
+	count := 0
+	for _, val := range bits {
+		if val != 0 {
+			count++
+		}
+	}
+	res := make([]byte, 0, binary.MaxVarintLen64+count*4)
+	res = appendUvarint(res, uint64(count))
+	last := 0
+	for idx, val := range bits {
+		if val == 0 {
+			continue
+		}
+		res = appendUvarint(res, uint64(idx-last))
+		res = append(res, val)
+		last = idx
+	}
+	return res
+}
+
+func decodeSparse(payload []byte, bits []byte) error {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return errors.New("sparse reply: bad count")
+	}
+	payload = payload[n:]
+	idx := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(payload)
+		if n <= 0 || len(payload) < n+1 {
+			return errors.New("sparse reply: truncated")
+		}
+		idx += delta
+		if idx >= uint64(len(bits)) {
+			return fmt.Errorf("sparse reply: index %d out of range", idx)
+		}
+		bits[idx] = payload[n]
+		payload = payload[n+1:]
+	}
+	if len(payload) != 0 {
+		return errors.New("sparse reply: trailing bytes")
+	}
+	return nil
+}
+
+// repeated (uvarint(zero run), uvarint(literal length), literal bytes)
+func encodeRLE(bits []byte) []byte {
+	res := make([]byte, 0)
+	for pos := 0; pos < len(bits); {
+		zeros := pos
+		for zeros < len(bits) && bits[zeros] == 0 {
+			zeros++
+		}
+		literal := zeros
+		for literal < len(bits) && bits[literal] != 0 {
+			literal++
+		}
+		res = appendUvarint(res, uint64(zeros-pos))
+		res = appendUvarint(res, uint64(literal-zeros))
+		res = append(res, bits[zeros:literal]...)
+		pos = literal
+	}
+	return res
+}
+
+func decodeRLE(payload []byte, bits []byte) error {
+	pos := uint64(0)
+	for len(payload) > 0 {
+		zeros, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return errors.New("rle reply: bad zero run")
+		}
+		payload = payload[n:]
+		literal, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < literal {
+			return errors.New("rle reply: truncated")
+		}
+		payload = payload[n:]
+		pos += zeros
+		if pos+literal > uint64(len(bits)) {
+			return errors.New("rle reply: run out of range")
+		}
+		copy(bits[pos:], payload[:literal])
+		payload = payload[literal:]
+		pos += literal
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
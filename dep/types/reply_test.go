@@ -0,0 +1,108 @@
+package types
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReplyRoundTrip(t *testing.T) {
+	tb := NewTraceBits()
+	tb.AddCount(0, 1)
+	tb.AddCount(0x1234, 0x4321)
+	for i := 0; i < 300; i++ {
+		tb.AddCount(0x7fff, 0xffff) // saturates at 255
+	}
+	tb.AddCount(0, 0xffff) // last index
+
+	for _, format := range []ReplyFormat{ReplyDense, ReplySparse, ReplyRLE} {
+		reply, err := tb.Encode(format)
+		if err != nil {
+			t.Fatalf("format %d: encode: %v", format, err)
+		}
+		if format != ReplyDense && uint64(len(reply)) >= TraceBitsSize {
+			t.Errorf("format %d: reply is not compressed, %d bytes", format, len(reply))
+		}
+		got, err := ReadReply(bytes.NewReader(reply), format)
+		if err != nil {
+			t.Fatalf("format %d: decode: %v", format, err)
+		}
+		if !bytes.Equal(got.GetBits(), tb.GetBits()) {
+			t.Errorf("format %d: decoded bits mismatch", format)
+		}
+	}
+}
+
+func TestReplyEmpty(t *testing.T) {
+	tb := NewTraceBits()
+	for _, format := range []ReplyFormat{ReplySparse, ReplyRLE} {
+		reply, err := tb.Encode(format)
+		if err != nil {
+			t.Fatalf("format %d: encode: %v", format, err)
+		}
+		got, err := ReadReply(bytes.NewReader(reply), format)
+		if err != nil {
+			t.Fatalf("format %d: decode: %v", format, err)
+		}
+		if !bytes.Equal(got.GetBits(), tb.GetBits()) {
+			t.Errorf("format %d: decoded bits mismatch", format)
+		}
+	}
+}
+
+func TestReplyMalformed(t *testing.T) {
+	if _, err := NewTraceBits().Encode(0xff); err == nil {
+		t.Error("unknown format should fail")
+	}
+	if ValidFormat(0xff) || !ValidFormat(ReplyRLE) {
+		t.Error("only known formats are valid")
+	}
+	// sparse reply claims one pair but carries none
+	if _, err := ReadReply(bytes.NewReader([]byte{ReplySparse, 0, 0, 0, 1, 1}), ReplySparse); err == nil {
+		t.Error("truncated sparse reply should fail")
+	}
+	// rle reply whose zero run exceeds the map size
+	if _, err := ReadReply(bytes.NewReader([]byte{ReplyRLE, 0, 0, 0, 5, 0x80, 0x80, 0x08, 1, 1}), ReplyRLE); err == nil {
+		t.Error("out of range rle reply should fail")
+	}
+	// length far beyond any encoding of the map is rejected before reading
+	_, err := ReadReply(bytes.NewReader([]byte{ReplySparse, 0xff, 0xff, 0xff, 0xff}), ReplySparse)
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("oversized reply should fail, got %v", err)
+	}
+}
+
+func TestReplyLongest(t *testing.T) {
+	// every bucket hit makes the longest sparse reply, every other one the
+	// longest rle reply; both are below the limit
+	for _, step := range []uint64{1, 2} {
+		tb := NewTraceBits()
+		for key := uint64(0); key < TraceBitsSize; key += step {
+			tb.bits[key] = 1
+		}
+		for _, format := range []ReplyFormat{ReplySparse, ReplyRLE} {
+			reply, err := tb.Encode(format)
+			if err != nil {
+				t.Fatalf("format %d: encode: %v", format, err)
+			}
+			if _, err := ReadReply(bytes.NewReader(reply), format); err != nil {
+				t.Errorf("format %d, step %d: decode: %v", format, step, err)
+			}
+		}
+	}
+}
+
+func TestStatement(t *testing.T) {
+	for _, sql := range []string{"", "select 1", strings.Repeat("x", MaxStatementSize+1)} {
+		got, err := ReadStatement(bytes.NewReader(EncodeStatement(sql)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sql) > MaxStatementSize {
+			sql = sql[:MaxStatementSize]
+		}
+		if got != sql {
+			t.Errorf("expect %d bytes, got %d bytes", len(sql), len(got))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TraceRoute is a ring buffer keeping the latest block ids executed by a
+// goroutine in order
+// NOTE: it's not thread safe, the TraceBits holding it locks for it
+type TraceRoute struct {
+	ids   []TraceRouteType
+	total uint64 // how many ids are added since last Take
+}
+
+func NewTraceRoute(size int) *TraceRoute {
+	if size <= 0 {
+		panic("size of TraceRoute should be positive")
+	}
+	return &TraceRoute{ids: make([]TraceRouteType, size)}
+}
+
+func (tr *TraceRoute) Add(id TraceRouteType) {
+	tr.ids[tr.total%uint64(len(tr.ids))] = id
+	tr.total++
+}
+
+// Take returns the recorded ids from the oldest to the newest with the
+// number of ids added, which is bigger than len(ids) if some are dropped,
+// then starts a new route
+func (tr *TraceRoute) Take() ([]TraceRouteType, uint64) {
+	size := uint64(len(tr.ids))
+	total := tr.total
+	var res []TraceRouteType
+	if total <= size {
+		res = append(res, tr.ids[:total]...)
+	} else {
+		start := total % size
+		res = append(res, tr.ids[start:]...)
+		res = append(res, tr.ids[:start]...)
+	}
+	tr.total = 0
+	return res, total
+}
+
+// EnableRoute starts recording the dst block of every count into the
+// route of the goroutine adding it, the latest size ones are kept
+func (tb *TraceBits) EnableRoute(size int) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	if size <= 0 {
+		panic("size of TraceRoute should be positive")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.routeSize = size
+	tb.routes = make(map[uintptr]*TraceRoute)
+}
+
+// NOTE: tb.mu must be held
+func (tb *TraceBits) addRoute(goroutine uintptr, id TraceRouteType) {
+	route, ok := tb.routes[goroutine]
+	if !ok {
+		route = NewTraceRoute(tb.routeSize)
+		tb.routes[goroutine] = route
+	}
+	route.Add(id)
+}
+
+// TakeRoute returns the route of the goroutine executing the most blocks
+// since last take, which is the session goroutine running the statement
+// rather than background workers, then starts new routes for all
+// goroutines. It returns nothing if route recording is not enabled
+func (tb *TraceBits) TakeRoute() ([]TraceRouteType, uint64) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	var busiest *TraceRoute
+	for _, route := range tb.routes {
+		if busiest == nil || route.total > busiest.total {
+			busiest = route
+		}
+	}
+	if tb.routeSize > 0 {
+		tb.routes = make(map[uintptr]*TraceRoute)
+	}
+	if busiest == nil {
+		return nil, 0
+	}
+	return busiest.Take()
+}
+
+// route reply: uint64 total, uint32 count, then count * uint16 block ids
+// Warn: its implementation relates to defination of TraceRouteType
+func EncodeRoute(ids []TraceRouteType, total uint64) []byte {
+	res := make([]byte, 12, 12+2*len(ids))
+	binary.BigEndian.PutUint64(res, total)
+	binary.BigEndian.PutUint32(res[8:], uint32(len(ids)))
+	for _, id := range ids {
+		res = append(res, byte(id>>8), byte(id))
+	}
+	return res
+}
+
+func ReadRoute(r io.Reader) ([]TraceRouteType, uint64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	total := binary.BigEndian.Uint64(header)
+	count := binary.BigEndian.Uint32(header[8:])
+	if uint64(count) > total {
+		return nil, 0, fmt.Errorf("route reply: %d ids more than total %d", count, total)
+	}
+	buf := make([]byte, 2*count)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, err
+	}
+	ids := make([]TraceRouteType, count)
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint16(buf[2*i:])
+	}
+	return ids, total, nil
+}
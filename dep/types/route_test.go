@@ -0,0 +1,49 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTraceRoute(t *testing.T) {
+	tb := NewTraceBits()
+	tb.AddCount(0, 1) // not recorded before enabled
+	tb.EnableRoute(3)
+
+	tb.AddCount(0, 2)
+	tb.AddCount(2, 3)
+	ids, total := tb.TakeRoute()
+	if !reflect.DeepEqual(ids, []TraceRouteType{2, 3}) || total != 2 {
+		t.Errorf("unexpected route %v, total %d", ids, total)
+	}
+
+	for dst := BlockIdType(1); dst <= 5; dst++ {
+		tb.AddCount(0, dst)
+	}
+	ids, total = tb.TakeRoute()
+	if !reflect.DeepEqual(ids, []TraceRouteType{3, 4, 5}) || total != 5 {
+		t.Errorf("unexpected wrapped route %v, total %d", ids, total)
+	}
+
+	// the session goroutine executes more blocks than background ones
+	tb.AddCountIn(1, 0, 7)
+	for dst := BlockIdType(1); dst <= 4; dst++ {
+		tb.AddCountIn(2, 0, dst)
+	}
+	tb.AddCountIn(1, 0, 8)
+	if ids, total := tb.TakeRoute(); !reflect.DeepEqual(ids, []TraceRouteType{2, 3, 4}) || total != 4 {
+		t.Errorf("unexpected session route %v, total %d", ids, total)
+	}
+	if ids, total := tb.TakeRoute(); ids != nil || total != 0 {
+		t.Errorf("routes are not reset %v, total %d", ids, total)
+	}
+
+	got, gotTotal, err := ReadRoute(bytes.NewReader(EncodeRoute(ids, total)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ids) || gotTotal != total {
+		t.Errorf("decoded route %v, total %d", got, gotTotal)
+	}
+}
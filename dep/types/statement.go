@@ -0,0 +1,39 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// statements longer than it are truncated
+const MaxStatementSize = 1 << 20
+
+// StatementAck is the reply of CmdSetStatement
+const StatementAck byte = 1
+
+// statement payload of CmdSetStatement: uint32 length, then the statement
+func EncodeStatement(sql string) []byte {
+	if len(sql) > MaxStatementSize {
+		sql = sql[:MaxStatementSize]
+	}
+	res := make([]byte, 4, 4+len(sql))
+	binary.BigEndian.PutUint32(res, uint32(len(sql)))
+	return append(res, sql...)
+}
+
+func ReadStatement(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxStatementSize {
+		return "", fmt.Errorf("statement of %d bytes is too long", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
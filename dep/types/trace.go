@@ -10,8 +10,15 @@ type TraceRouteType = uint16         // same as block id
 const TraceBitsSize uint64 = 1 << 16 // is 1 << 32 if BlockIdType is uint32
 
 type TraceBits struct {
-	bits [TraceBitsSize]byte
-	mu   sync.RWMutex
+	bits      [TraceBitsSize]byte
+	routeSize int                     // 0 unless route recording is enabled
+	routes    map[uintptr]*TraceRoute // of every goroutine since last TakeRoute
+	prevLoc   map[uintptr]BlockIdType
+
+	contextDepth int // 0 unless call context is enabled
+	contexts     map[uintptr]*callContext
+
+	mu sync.RWMutex
 }
 
 func NewTraceBits() *TraceBits {
@@ -27,13 +34,17 @@ func (tb *TraceBits) GetCount(src, dst BlockIdType) (uint8, error) {
 	return tb.bits[src^dst], nil
 }
 
+// GetBits returns a copy of the bits, so the caller can read it
+// without racing with the goroutines adding counts
 func (tb *TraceBits) GetBits() []byte {
 	if tb == nil {
 		return nil
 	}
 	tb.mu.RLock()
 	defer tb.mu.RUnlock()
-	return tb.bits[:]
+	res := make([]byte, TraceBitsSize)
+	copy(res, tb.bits[:])
+	return res
 }
 
 // see: http://rk700.github.io/2017/12/28/afl-internals/#%E5%88%86%E6%94%AF%E4%BF%A1%E6%81%AF%E7%9A%84%E5%88%86%E6%9E%90
@@ -44,26 +55,61 @@ func (tb *TraceBits) ClassifyCount() error {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	for key, val := range tb.bits {
-		switch {
-		case val < 3:
-			break
-		case val == 3:
-			tb.bits[key] = 4
-		case val < 8:
-			tb.bits[key] = 8
-		case val < 16:
-			tb.bits[key] = 16
-		case val < 32:
-			tb.bits[key] = 32
-		case val < 128:
-			tb.bits[key] = 64
-		default:
-			tb.bits[key] = 128
-		}
+		tb.bits[key] = classify(val)
 	}
 	return nil
 }
 
+// Classified returns a classified copy and keeps the raw counts in tb
+func (tb *TraceBits) Classified() *TraceBits {
+	if tb == nil {
+		return nil
+	}
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	res := NewTraceBits()
+	for key, val := range tb.bits {
+		res.bits[key] = classify(val)
+	}
+	return res
+}
+
+// Snapshot takes away the raw counts and resets tb in one critical section,
+// so every count added concurrently goes to exactly one snapshot. Previous
+// locations are reset too, so the next execution doesn't start with an
+// edge from the last block of the previous one
+func (tb *TraceBits) Snapshot() *TraceBits {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	res := NewTraceBits()
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	res.bits = tb.bits
+	tb.bits = [TraceBitsSize]byte{0}
+	tb.prevLoc = nil
+	return res
+}
+
+func classify(val byte) byte {
+	switch {
+	case val < 3:
+		return val
+	case val == 3:
+		return 4
+	case val < 8:
+		return 8
+	case val < 16:
+		return 16
+	case val < 32:
+		return 32
+	case val < 128:
+		return 64
+	default:
+		return 128
+	}
+}
+
 // src will be lsift 1 in building stage; avoid cases like A^A=0, A^B=B^A
 func (tb *TraceBits) AddCount(src, dst BlockIdType) {
 	if tb == nil {
@@ -72,10 +118,37 @@ func (tb *TraceBits) AddCount(src, dst BlockIdType) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	key := (src << 1) ^ dst
+	tb.addKey(0, (src<<1)^dst, dst)
+}
+
+// AddBlock is for dynamic edges: the edge is computed from the block id
+// executed previously by the same goroutine, so edges across functions,
+// loop back-edges and returns are recorded, like `prev_loc` in AFL.
+// A new goroutine starts from location 0
+func (tb *TraceBits) AddBlock(goroutine uintptr, cur BlockIdType) {
+	if tb == nil {
+		panic("TraceBits has not been initialized")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.prevLoc == nil {
+		tb.prevLoc = make(map[uintptr]BlockIdType)
+	}
+	key := tb.prevLoc[goroutine] ^ cur ^ tb.contextHash(goroutine)
+	// shift it so that A->B differs from B->A, and A->A is not 0
+	tb.prevLoc[goroutine] = cur >> 1
+	tb.addKey(goroutine, key, cur)
+}
+
+// NOTE: tb.mu must be held; goroutine is 0 if it's unknown
+func (tb *TraceBits) addKey(goroutine uintptr, key BlockIdType, cur BlockIdType) {
 	if tb.bits[key] != 255 { // avoid overflow
 		tb.bits[key]++
 	}
+	if tb.routeSize > 0 {
+		tb.addRoute(goroutine, cur)
+	}
 }
 
 func (tb *TraceBits) Clean() {
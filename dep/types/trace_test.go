@@ -0,0 +1,101 @@
+package types
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestClassified(t *testing.T) {
+	tb := NewTraceBits()
+	for i := 0; i < 5; i++ {
+		tb.AddCount(1, 2)
+	}
+	classified := tb.Classified()
+
+	raw, _ := tb.GetCount(1<<1, 2)
+	if raw != 5 {
+		t.Errorf("raw count changed to %d", raw)
+	}
+	bucket, _ := classified.GetCount(1<<1, 2)
+	if bucket != 8 {
+		t.Errorf("expect bucket 8, got %d", bucket)
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	const goroutines, rounds = 8, 100
+	tb := NewTraceBits()
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				tb.AddCount(0, BlockIdType(g))
+			}
+		}(g)
+	}
+
+	total := make([]int, goroutines)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	collect := func() {
+		bits := tb.Snapshot().GetBits()
+		for g := range total {
+			total[g] += int(bits[g])
+		}
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		collect()
+	}
+	collect()
+
+	// every count is in exactly one snapshot
+	for g, n := range total {
+		if n != rounds {
+			t.Errorf("goroutine %d: expect %d counts, got %d", g, rounds, n)
+		}
+	}
+}
+
+func TestAddBlock(t *testing.T) {
+	tb := NewTraceBits()
+	tb.AddBlock(1, 0x10)
+	tb.AddBlock(1, 0x20)
+	tb.AddBlock(2, 0x20) // another goroutine starts from 0
+	tb.AddBlock(1, 0x10) // back edge
+
+	bits := tb.GetBits()
+	expect := map[int]byte{
+		0x10:               1,
+		(0x10 >> 1) ^ 0x20: 1,
+		0x20:               1,
+		(0x20 >> 1) ^ 0x10: 1,
+	}
+	for key, val := range expect {
+		if bits[key] != val {
+			t.Errorf("key %#x: expect %d, got %d", key, val, bits[key])
+		}
+	}
+}
+
+func TestSnapshotResetsPrevLoc(t *testing.T) {
+	tb := NewTraceBits()
+	tb.AddBlock(1, 0x10)
+	tb.Snapshot()
+	tb.AddBlock(1, 0x20) // starts from 0 again
+
+	bits := tb.GetBits()
+	if bits[0x20] != 1 || bits[(0x10>>1)^0x20] != 0 {
+		t.Error("the edge from the previous execution should not be recorded")
+	}
+}
@@ -12,19 +12,46 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
+	dtypes "github.com/Illyrix/tidb-go-fuzz/dep/types"
 	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg"
 	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/builder"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
 	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/types"
 )
 
 var flagIsRemote = flag.Bool("remote", false, "using remote tidb repo")
 var flagSrcDir = flag.String("src", "", "path to local tidb repo")
 var flagTargetDir = flag.String("target", "/tmp/tidb-go-fuzz", "path to modified tidb source code; should be empty")
+var flagMetrics = flag.Bool("metrics", false, "expose coverage metrics with tidb metrics on its status port")
+var flagMetricsAddr = flag.String("metrics-addr", "", "also serve coverage metrics on this address if not empty")
+var flagCoverageDump = flag.String("coverage-dump", "", "file that tidb dumps coverage into on exit and SIGUSR1; empty to disable")
+var flagTraceRoute = flag.Int("trace-route", 0, "record the latest N blocks executed by each goroutine in order; 0 to disable")
+var flagDynamicEdge = flag.Bool("dynamic-edge", false, "compute edges at runtime from the block executed previously by the same goroutine")
+var flagCallContext = flag.Int("call-context", 0, "mix the innermost N called functions into edges; 0 to disable")
+var flagCmpLog = flag.Int("cmp-log", 0, "trace operands of the latest N comparisons with literals; 0 to disable")
+var flagRecoverLog = flag.Int("recover-log", 0, "report the first N panics recovered by tidb in every statement; 0 to disable")
+var flagCrashFile = flag.String("crash-file", "", "file that tidb appends the stack and last statement to before os.Exit or fatal logs; empty to disable")
+var flagGranularity = flag.String("granularity", "block", "where counters are added: block, func (function entry only) or sample (a subset of blocks)")
+var flagGranularityPkgs = flag.String("granularity-pkg", "", "per package granularity overriding -granularity, like `util/chunk=func,ddl=sample`")
+var flagSampleRate = flag.Int("sample-rate", 4, "1 of N blocks is instrumented in sampled granularity")
+var flagProfile = flag.String("pprof", "", "cpu profile of uninstrumented tidb-server; its hottest functions are excluded from instrumentation")
+var flagHotFraction = flag.Float64("pprof-hot", 0.5, "exclude the hottest functions together taking this fraction of cpu time of tidb functions")
+var flagHotGranularity = flag.String("pprof-hot-granularity", "skip", "granularity of hot functions: skip, func or sample")
+var flagReachableFrom = flag.String("reachable-from", "", "only instrument functions reachable from these functions, like `session.(*session).ExecuteStmt,...`")
+var flagInstrumentModules = flag.String("instrument-mod", "", "also instrument these dependency modules, like `github.com/pingcap/parser,github.com/pingcap/tipb`")
+var flagDictMin = flag.Int("dict-min", 2, "only tokens found at least N times in tidb source are written into the dictionary")
+var flagCoverageDumpInterval = flag.Int("coverage-dump-interval", 0, "seconds between periodical coverage dumps; 0 to disable")
 
 var ignoreFiles map[string]struct{} = make(map[string]struct{})
+var blocks []*dtypes.Block
+var granularityRules *builder.GranularityRules
+var funcGranularity map[string]builder.Granularity
+var reachable map[string]bool
+var modulePath string
+var modules []*builder.Module
+var dictionary = dict.New()
 var void struct{}
 
 func main() {
@@ -34,13 +61,47 @@ func main() {
 		TidbSrcDir:     *flagSrcDir,
 		TidbFromRemote: *flagIsRemote,
 		TidbTargetDir:  *flagTargetDir,
+		EnableMetrics:  *flagMetrics,
+		MetricsAddr:    *flagMetricsAddr,
+
+		BuildID:              builder.NewBuildID(),
+		CoverageDumpPath:     *flagCoverageDump,
+		CoverageDumpInterval: *flagCoverageDumpInterval,
+		TraceRouteSize:       *flagTraceRoute,
+		DynamicEdge:          *flagDynamicEdge,
+		CallContextDepth:     *flagCallContext,
+		CmpLogSize:           *flagCmpLog,
+		RecoverLogSize:       *flagRecoverLog,
+		CrashFile:            *flagCrashFile,
+
+		Granularity:     *flagGranularity,
+		GranularityPkgs: *flagGranularityPkgs,
+		SampleRate:      *flagSampleRate,
+
+		Profile:        *flagProfile,
+		HotFraction:    *flagHotFraction,
+		HotGranularity: *flagHotGranularity,
+
+		ReachableRoots: *flagReachableFrom,
+
+		InstrumentModules: *flagInstrumentModules,
+
+		DictMin: *flagDictMin,
 	}
 
 	if err := config.Valid(); err != nil {
 		panic(err)
 	}
+	defaultGranularity, err := builder.ParseGranularity(config.Granularity)
+	if err != nil {
+		panic(err)
+	}
+	granularityRules, err = builder.ParseGranularityRules(defaultGranularity, config.GranularityPkgs)
+	if err != nil {
+		panic(err)
+	}
 
-	err := os.MkdirAll(*flagTargetDir, os.ModePerm)
+	err = os.MkdirAll(*flagTargetDir, os.ModePerm)
 	if err != nil {
 		log.Fatalf("Fatal Error: target dir %s create fail %v\n", *flagTargetDir, err)
 	}
@@ -53,6 +114,37 @@ func main() {
 	// copy tidb source code to target dir
 	pkg.Copy(*flagSrcDir, *flagTargetDir)
 
+	modulePath, err = builder.ModulePath(*flagTargetDir)
+	if err != nil {
+		log.Fatalf("Fatal Error: read module path fail %v\n", err)
+	}
+	if config.InstrumentModules != "" {
+		modules, err = builder.VendorModules(*flagTargetDir, strings.Split(config.InstrumentModules, ","))
+		if err != nil {
+			log.Fatalf("Fatal Error: vendor modules fail %v\n", err)
+		}
+		for _, m := range modules {
+			fmt.Printf("Instrumenting module %s %s\n", m.Path, m.Version)
+		}
+	}
+	modulePaths := []string{modulePath}
+	for _, m := range modules {
+		modulePaths = append(modulePaths, m.Path)
+	}
+
+	// analyze before any counter is added
+	if config.ReachableRoots != "" {
+		roots := strings.Split(config.ReachableRoots, ",")
+		reachable, err = builder.ReachableFuncs(*flagTargetDir, modulePaths, roots)
+		if err != nil {
+			log.Fatalf("Fatal Error: call graph analysis fail %v\n", err)
+		}
+		fmt.Printf("Instrumenting %d functions reachable from %s\n", len(reachable), config.ReachableRoots)
+	}
+	if config.Profile != "" {
+		excludeHotFuncs(&config, modulePaths)
+	}
+
 	// walk on every file
 	err = filepath.Walk(*flagTargetDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -69,13 +161,7 @@ func main() {
 			if err != nil {
 				panic(path + " read error\n")
 			}
-			// keep build constaints like: // +build linux
-			buildComments := findCrucialComments(src)
-			modifiedFile := addCounter(src)
-			if len(buildComments) > 0 {
-				// add build constaints back to source file
-				modifiedFile = addBackComments(buildComments, modifiedFile)
-			}
+			modifiedFile := addCounter(path, src, &config)
 			err = ioutil.WriteFile(path, modifiedFile, os.ModePerm)
 			if err != nil {
 				panic(fmt.Sprintf("%s write error %v\n", path, err))
@@ -87,8 +173,38 @@ func main() {
 		panic("walk files for adding counters failed")
 	}
 
+	blockMap := filepath.Join(*flagTargetDir, builder.BLOCK_MAP_FILE)
+	if err := builder.WriteBlockMap(blockMap, *flagTargetDir, blocks); err != nil {
+		log.Fatalf("Fatal Error: block map %s write fail %v\n", blockMap, err)
+	}
+	dictFile := filepath.Join(*flagTargetDir, builder.DICT_FILE)
+	if err := writeDict(dictFile, dictionary.Tokens(config.DictMin)); err != nil {
+		log.Fatalf("Fatal Error: dictionary %s write fail %v\n", dictFile, err)
+	}
+
 	// add listen in tidb-server/main.go
 	builder.AddListenStart(*flagTargetDir)
+	if config.TraceRouteSize > 0 {
+		builder.AddTraceRoute(*flagTargetDir, config.TraceRouteSize)
+	}
+	if config.CallContextDepth > 0 {
+		builder.AddCallContext(*flagTargetDir, config.CallContextDepth)
+	}
+	if config.CmpLogSize > 0 {
+		builder.AddCmpLog(*flagTargetDir, config.CmpLogSize)
+	}
+	if config.RecoverLogSize > 0 {
+		builder.AddRecoverLog(*flagTargetDir, config.RecoverLogSize)
+	}
+	if config.CrashFile != "" {
+		builder.AddCrashReport(*flagTargetDir, config.CrashFile)
+	}
+	if config.EnableMetrics {
+		builder.AddMetricsStart(*flagTargetDir, config.MetricsAddr)
+	}
+	if config.CoverageDumpPath != "" {
+		builder.AddCoverageDump(*flagTargetDir, config.CoverageDumpPath, config.BuildID, config.CoverageDumpInterval)
+	}
 
 	// install dependency
 	fmt.Println("Installing dependency")
@@ -97,17 +213,53 @@ func main() {
 	fmt.Println("Compiling tidb")
 	builder.CompileTidb(*flagTargetDir)
 
-	fmt.Printf("Done! Run `%s` to start tidb server", "")
+	fmt.Printf("Done! Build id %s. Run `%s` to start tidb server", config.BuildID, "")
 }
 
-func addCounter(src []byte) []byte {
-	fset, astFile := parse(src)
+func writeDict(path string, tokens []dict.Token) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := dict.Write(f, tokens); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func addCounter(path string, src []byte, config *types.Config) []byte {
+	fset, astFile := parse(path, src)
+	builder.CollectTokens(dictionary, astFile)
 
 	visitor := builder.NewVisitorPtr(fset)
+	if config.DynamicEdge {
+		visitor.Mode = builder.DynamicEdge
+	}
+	visitor.CallContext = config.CallContextDepth > 0
+	visitor.TraceRoute = config.TraceRouteSize > 0
+	visitor.CmpLog = config.CmpLogSize > 0
+	visitor.Recover = config.RecoverLogSize > 0
+	visitor.HookExit = config.CrashFile != ""
+	visitor.SampleRate = config.SampleRate
+	visitor.Granularity = granularityRules.Default
+	visitor.PkgPath = modulePath
+	if rel, err := filepath.Rel(config.TidbTargetDir, filepath.Dir(path)); err == nil {
+		visitor.Granularity = granularityRules.For(rel)
+		visitor.PkgPath = builder.PackagePath(modulePath, rel)
+	}
+	for _, m := range modules {
+		if rel, err := filepath.Rel(m.Dir, filepath.Dir(path)); err == nil && !strings.HasPrefix(rel, "..") {
+			visitor.PkgPath = builder.PackagePath(m.Path, rel)
+		}
+	}
+	visitor.Funcs = funcGranularity
+	visitor.Reachable = reachable
 	ast.Walk(visitor, astFile)
 	if visitor.Changed {
 		visitor.AddImportDecl(astFile)
 	}
+	blocks = append(blocks, visitor.Blocks()...)
 
 	out := new(bytes.Buffer)
 	cfg := printer.Config{
@@ -119,91 +271,40 @@ func addCounter(src []byte) []byte {
 	return out.Bytes()
 }
 
-func parse(content []byte) (*token.FileSet, *ast.File) {
-	fset := token.NewFileSet()
-	aFile, err := parser.ParseFile(fset, "", content, 0)
+// the hottest functions in the profile are instrumented in HotGranularity
+func excludeHotFuncs(config *types.Config, modulePaths []string) {
+	g := builder.SkipGranularity
+	if config.HotGranularity != g.String() {
+		var err error
+		if g, err = builder.ParseGranularity(config.HotGranularity); err != nil {
+			panic(err)
+		}
+	}
+	hot, err := builder.LoadHotFuncs(config.Profile, append(builder.ModulePrefixes(modulePaths), "main."), config.HotFraction)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Fatal Error: load profile %s fail %v\n", config.Profile, err)
 	}
 
-	return fset, aFile
-}
-
-// get all build constraints
-func findCrucialComments(src []byte) []buildComment {
-	str := string(src[:])
-	lines := strings.Split(str, "\n")
-	res := make([]buildComment, 0)
-
-	buildReg := regexp.MustCompile(`// \+build .+`)
-	generateReg := regexp.MustCompile(`//go:generate .+`)
-	linknameReg := regexp.MustCompile(`//go:linkname .+`)
-
-	for idx, line := range lines {
-		if buildReg.MatchString(line) {
-			res = append(res, buildComment{true, "", "", line + "\n\n"})
-			continue
-		}
-		if generateReg.MatchString(line) {
-			res = append(res, buildComment{true, "", "", line + "\n\n"})
-			continue
-		}
-		if linknameReg.MatchString(line) {
-			if len(lines) <= idx+1 {
-				panic(fmt.Sprintf("no line after //go:linkname at line %d", idx))
-			}
-			nextLine := lines[idx+1]
-			res = append(res, buildComment{false, nextLine, "", "\n" + line})
-		}
+	funcGranularity = make(map[string]builder.Granularity)
+	fmt.Printf("Instrumenting %d hot functions in %s granularity\n", len(hot), g)
+	for _, fn := range hot {
+		funcGranularity[fn.Name] = g
+		fmt.Printf("  %6.2f%% %s\n", fn.Share*100, fn.Name)
+	}
+	hotFuncs := filepath.Join(config.TidbTargetDir, builder.HOT_FUNCS_FILE)
+	if err := builder.WriteHotFuncs(hotFuncs, hot, g); err != nil {
+		log.Fatalf("Fatal Error: hot functions %s write fail %v\n", hotFuncs, err)
 	}
-	return res
 }
 
-func addBackComments(comments []buildComment, src []byte) []byte {
-	res := string(src)
-	for _, comment := range comments {
-		if comment.atHead {
-			res = comment.comment + res
-			continue
-		}
-		if comment.before != "" {
-			lines := strings.Split(res, "\n")
-			for idx, line := range lines {
-				if line == comment.before {
-					// before := lines[:idx-1]
-					before := make([]string, idx)
-					copy(before, lines[:idx])
-					fmt.Println("  BEFORE: " + before[len(before)-1])
-					before = append(before, comment.comment)
-					fmt.Println("  INSERT: " + comment.comment)
-					fmt.Println("  COMMENT_BEFORE:" + comment.before)
-					after := lines[idx:]
-					fmt.Println("  AFTER: " + after[0])
-					res = strings.Join(append(before, after...), "\n")
-					break
-				}
-				continue
-			}
-		} else if comment.after != "" {
-			lines := strings.Split(res, "\n")
-			for idx, line := range lines {
-				if line == comment.after {
-					before := lines[:idx-1]
-					before = append(before, comment.comment)
-					after := lines[idx-1:]
-					res = strings.Join(append(before, after...), "\n")
-					break
-				}
-				continue
-			}
-		}
+func parse(path string, content []byte) (*token.FileSet, *ast.File) {
+	fset := token.NewFileSet()
+	aFile, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		panic(err)
 	}
-	return []byte(res)
-}
+	// build constraints, //go:embed, //go:linkname etc. are kept
+	builder.KeepDirectives(aFile)
 
-type buildComment struct {
-	atHead  bool
-	before  string // this comment is before some line
-	after   string // this comment is after some line
-	comment string
+	return fset, aFile
 }
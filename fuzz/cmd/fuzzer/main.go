@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/fuzzer/sqlfuzz"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/types"
+)
+
+var flagDSN = flag.String("dsn", "root@tcp(127.0.0.1:4000)/", "dsn of the instrumented tidb-server")
+var flagTraceAddr = flag.String("trace-addr", dep.ListenAddress, "address of the trace server in tidb-server")
+var flagSeeds = flag.String("seeds", "", "file of initial inputs, one input per line; or a directory of them, one input per `.sql` file")
+var flagPrepare = flag.String("prepare", "", "statements executed before every input, like `use test`")
+var flagDict = flag.String("dict", "", "dictionary in AFL format, like tidb-go-fuzz-dict.txt written by the builder in the target dir")
+var flagGrammar = flag.String("grammar", "", "parser.y, or a tidb checkout having it; statements are also generated from the grammar")
+var flagGrammarStart = flag.String("grammar-start", "", "nonterminal of the grammar the statements are derived from; `Statement` by default")
+var flagSchedule = flag.String("schedule", "fast", "power schedule of the queue: explore, fast, coe, exploit or rare")
+var flagTimeout = flag.Duration("timeout", 5*time.Second, "timeout of executing an input")
+var flagPanics = flag.Bool("panics", false, "report panics recovered by tidb; tidb must be built with -recover-log")
+var flagStatsInterval = flag.Duration("stats-interval", 10*time.Second, "interval between printing stats; 0 to disable")
+var flagRandSeed = flag.Int64("seed", 0, "seed of random mutations; 0 to use current time")
+var flagCampaign = flag.String("campaign", "", "directory keeping queue, crashes, hangs, coverage and stats of the run; empty to keep them in memory")
+var flagResume = flag.Bool("resume", false, "continue the run in -campaign")
+var flagBuildID = flag.String("build-id", "", "build id of the instrumented tidb printed by the builder; coverage of another build is not reused on resume")
+
+func main() {
+	flag.Parse()
+
+	config := types.FuzzerConfig{
+		DSN:           *flagDSN,
+		TraceAddr:     *flagTraceAddr,
+		Prepare:       *flagPrepare,
+		Timeout:       *flagTimeout,
+		Panics:        *flagPanics,
+		StatsInterval: *flagStatsInterval,
+		RandSeed:      *flagRandSeed,
+		Grammar:       *flagGrammar,
+		GrammarStart:  *flagGrammarStart,
+		Schedule:      *flagSchedule,
+
+		CampaignDir: *flagCampaign,
+		Resume:      *flagResume,
+		BuildID:     *flagBuildID,
+	}
+	if config.RandSeed == 0 {
+		config.RandSeed = time.Now().UnixNano()
+	}
+	if *flagSeeds != "" {
+		seeds, err := sqlfuzz.LoadSeeds(*flagSeeds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Seeds = seeds
+	}
+
+	if *flagDict != "" {
+		tokens, err := readDict(*flagDict)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Dict = tokens
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	f, err := sqlfuzz.NewSQLFuzzer(ctx, &config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("fuzzing %s with %d seeds, random seed %d", config.DSN, len(config.Seeds), config.RandSeed)
+	if err := f.Run(); err != nil {
+		log.Fatal(err)
+	}
+	s := f.Stats()
+	log.Printf("done: execs %d, corpus %d, edges %d, crashes %d, panics %d",
+		s.Execs, f.Corpus().Len(), f.Coverage().EverHit(), s.Crashes, s.Panics)
+}
+
+func readDict(path string) ([]dict.Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dict.Read(f)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/fuzzer/sqlfuzz"
+)
+
+var flagSrcDir = flag.String("src", "", "path to local tidb repo")
+var flagOutDir = flag.String("out", "seeds", "directory the seeds are written into, one `.sql` file per test")
+
+func main() {
+	flag.Parse()
+	if *flagSrcDir == "" {
+		log.Fatal("path to tidb repo is not assigned")
+	}
+
+	scripts, err := sqlfuzz.ImportSeeds(*flagSrcDir)
+	if err != nil {
+		log.Fatalf("import seeds error %v", err)
+	}
+	if err := sqlfuzz.WriteSeeds(*flagOutDir, scripts); err != nil {
+		log.Fatalf("write seeds error %v", err)
+	}
+	statements := 0
+	for _, script := range scripts {
+		statements += len(script.Statements)
+	}
+	fmt.Printf("Done! %d statements of %d tests are written into %s. Run `tidb-fuzz-fuzzer -seeds %s` to use them\n",
+		statements, len(scripts), *flagOutDir, *flagOutDir)
+}
@@ -0,0 +1,221 @@
+package builder
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// names of temporary variables injected for comparison tracing
+const CMP_OPERAND_NAME = "__tidb_go_fuzz_cmp"
+const SWITCH_TAG_NAME = "__tidb_go_fuzz_tag"
+
+func isComparison(op token.Token) bool {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return true
+	}
+	return false
+}
+
+// cmpLiteral returns the value of a string, char or (negative) int literal
+// as the runtime formats operands: chars and ints are in decimal
+func cmpLiteral(e ast.Expr) (string, bool) {
+	negative := false
+	if u, ok := e.(*ast.UnaryExpr); ok && u.Op == token.SUB {
+		negative = true
+		e = u.X
+	}
+	lit, ok := e.(*ast.BasicLit)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case lit.Kind == token.STRING && !negative:
+		str, err := strconv.Unquote(lit.Value)
+		return str, err == nil
+	case lit.Kind == token.CHAR && !negative:
+		str, err := strconv.Unquote(lit.Value)
+		if err != nil || len([]rune(str)) != 1 {
+			return "", false
+		}
+		return strconv.Itoa(int([]rune(str)[0])), true
+	case lit.Kind == token.INT:
+		text := lit.Value
+		if negative {
+			text = "-" + text
+		}
+		if val, err := strconv.ParseInt(text, 0, 64); err == nil {
+			return strconv.FormatInt(val, 10), true
+		}
+		if val, err := strconv.ParseUint(text, 0, 64); err == nil {
+			return strconv.FormatUint(val, 10), true
+		}
+		return strings.Replace(text, "_", "", -1), true
+	}
+	return "", false
+}
+
+// x == "abc"
+// ==>
+// func() bool { __cmp := x; TraceCmp(id, "==", __cmp, "abc"); return __cmp == "abc" }() == true
+//
+// only comparisons with a literal are traced: without type checking we can't
+// tell whether an identifier is an untyped constant, and assigning it to the
+// temporary variable may change its type. The literal is passed as a string,
+// so it's never converted to an interface and overflows int.
+// Returns false if t is not rewritten
+func (v *Visitor) instrumentCmp(t *ast.BinaryExpr) bool {
+	litExpr, other := t.Y, t.X
+	expected, ok := cmpLiteral(litExpr)
+	if !ok {
+		litExpr, other = t.X, t.Y
+		if expected, ok = cmpLiteral(litExpr); !ok {
+			return false
+		}
+	}
+	if _, ok := cmpLiteral(other); ok {
+		return false // constant expression
+	}
+
+	// instrument closures and comparisons inside the operand first,
+	// because the rewritten expression won't be walked again
+	ast.Walk(v, other)
+
+	tmp := ast.NewIdent(CMP_OPERAND_NAME)
+	result := &ast.BinaryExpr{X: tmp, Op: t.Op, Y: litExpr}
+	if litExpr == t.X {
+		result = &ast.BinaryExpr{X: litExpr, Op: t.Op, Y: tmp}
+	}
+	t.X = &ast.CallExpr{
+		Fun: &ast.FuncLit{
+			Type: &ast.FuncType{Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("bool")}}}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{tmp}, Tok: token.DEFINE, Rhs: []ast.Expr{other}},
+				makeDepCall("TraceCmp", makeIntLit(genBlockId()), makeStringLit(t.Op.String()), tmp, makeStringLit(expected)),
+				&ast.ReturnStmt{Results: []ast.Expr{result}},
+			}},
+		},
+	}
+	// keep the result an untyped bool, so it's still assignable to named bool types
+	t.Op = token.EQL
+	t.Y = ast.NewIdent("true")
+	v.setChanged()
+	return true
+}
+
+// switch x { case "a", "b": ... case y: ... }
+// ==>
+// switch __tag := x; { case __tag == "a", __tag == "b": ... case __tag == y: ... }
+//
+// so the comparisons with literals are traced by instrumentCmp. It's the same
+// as how the spec evaluates switch statements. Init statements leave no room
+// for the temporary variable, so they're hoisted by hoistSwitchInits before
+func (v *Visitor) instrumentSwitch(t *ast.SwitchStmt) {
+	if t.Init != nil || !isCmpSwitch(t) {
+		return
+	}
+
+	t.Init = &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(SWITCH_TAG_NAME)},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{t.Tag},
+	}
+	t.Tag = nil
+	for _, s := range t.Body.List {
+		clause := s.(*ast.CaseClause)
+		for i, e := range clause.List {
+			clause.List[i] = &ast.BinaryExpr{X: ast.NewIdent(SWITCH_TAG_NAME), Op: token.EQL, Y: e}
+		}
+	}
+	v.setChanged()
+}
+
+// isCmpSwitch tells if some cases of the switch compare its tag with literals
+func isCmpSwitch(t *ast.SwitchStmt) bool {
+	if t.Tag == nil || t.Body == nil {
+		return false
+	}
+	if _, ok := cmpLiteral(t.Tag); ok {
+		return false
+	}
+	for _, s := range t.Body.List {
+		for _, e := range s.(*ast.CaseClause).List {
+			if _, ok := cmpLiteral(e); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// switch x := f(); x { ... }
+// ==>
+// { x := f(); switch x { ... } }
+//
+// for switches in stmts, or bodies of clauses in stmts, to be rewritten by
+// instrumentSwitch. The block keeps the scope of the init statement. Labeled
+// switches are left as is, since `break` and `goto` need the label outside
+func hoistSwitchInits(stmts []ast.Stmt) {
+	for i, s := range stmts {
+		switch t := s.(type) {
+		case *ast.CaseClause:
+			hoistSwitchInits(t.Body)
+		case *ast.CommClause:
+			hoistSwitchInits(t.Body)
+		case *ast.SwitchStmt:
+			if t.Init == nil || !isCmpSwitch(t) {
+				continue
+			}
+			stmts[i] = &ast.BlockStmt{Lbrace: t.Pos(), List: []ast.Stmt{t.Init, t}, Rbrace: t.End() - 1}
+			t.Init = nil
+		}
+	}
+}
+
+// strings.EqualFold(a, b) ==> strings.EqualFold(TraceEqualFold(id, a, b))
+// bytes.Equal(a, b) ==> bytes.Equal(TraceBytesEqual(id, a, b))
+//
+// the original call is kept, so the import is still used
+func (v *Visitor) instrumentCmpCall(t *ast.CallExpr) {
+	sel, ok := t.Fun.(*ast.SelectorExpr)
+	if !ok || len(t.Args) != 2 || t.Ellipsis.IsValid() {
+		return
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return
+	}
+	var trace string
+	switch v.imports[pkg.Name] + "." + sel.Sel.Name {
+	case "strings.EqualFold":
+		trace = "TraceEqualFold"
+	case "bytes.Equal":
+		trace = "TraceBytesEqual"
+	default:
+		return
+	}
+	t.Args = []ast.Expr{makeDepCallExpr(trace, makeIntLit(genBlockId()), t.Args[0], t.Args[1])}
+	v.setChanged()
+}
+
+// local name => import path
+func fileImports(f *ast.File) map[string]string {
+	res := make(map[string]string)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "_" || name == "." {
+			continue
+		}
+		res[name] = path
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+package builder
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+)
+
+// the dictionary file written by the builder
+const DICT_FILE = "tidb-go-fuzz-dict.txt"
+
+// literals looking like SQL tokens: keywords (up to 3 words like
+// `ON DUPLICATE KEY`), function names, and variables like `@@tidb_snapshot`
+var tokenLit = regexp.MustCompile(`^@{0,2}[A-Za-z_][A-Za-z0-9_$.]*( [A-Za-z_][A-Za-z0-9_]*){0,2}$`)
+
+const maxTokenSize = 64
+
+// CollectTokens adds string literals in file looking like SQL tokens into d,
+// except import paths and struct tags
+func CollectTokens(d *dict.Dictionary, file *ast.File) {
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.ImportSpec:
+			return false
+		case *ast.Field:
+			// the tag is skipped, but the type may have literals like array sizes
+			if n.Type != nil {
+				ast.Inspect(n.Type, func(node ast.Node) bool {
+					addTokenLit(d, node)
+					return true
+				})
+			}
+			return false
+		default:
+			addTokenLit(d, node)
+		}
+		return true
+	})
+}
+
+func addTokenLit(d *dict.Dictionary, node ast.Node) {
+	lit, ok := node.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil || len(value) < 2 || len(value) > maxTokenSize || !tokenLit.MatchString(value) {
+		return
+	}
+	d.Add(value)
+}
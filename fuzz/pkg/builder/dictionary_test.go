@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+	"github.com/stretchr/testify/assert"
+)
+
+const dictCode = `package ast
+
+import "fmt"
+
+const (
+	Abs      = "abs"
+	Snapshot = "@@tidb_snapshot"
+)
+
+type T struct {
+	A int ` + "`json:\"a\"`" + `
+}
+
+var tokenMap = map[string]int{
+	"ON DUPLICATE KEY": 1,
+	"SELECT":           2,
+}
+
+func f() {
+	fmt.Printf("failed to get %s, it should be a long log message", "SELECT")
+	_ = "x"
+}
+`
+
+func TestCollectTokens(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "ast.go", dictCode, 0)
+	assert.Nil(t, err)
+
+	d := dict.New()
+	CollectTokens(d, file)
+	assert.Equal(t, []dict.Token{
+		{Value: "SELECT", Count: 2},
+		{Value: "@@tidb_snapshot", Count: 1},
+		{Value: "ON DUPLICATE KEY", Count: 1},
+		{Value: "abs", Count: 1},
+	}, d.Tokens(1))
+}
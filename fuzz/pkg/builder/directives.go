@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// KeepDirectives drops comments of file parsed with comments except
+// directives, like `//go:build`, `// +build`, `//go:embed` and
+// `//go:linkname`, and the preamble of `import "C"`. They're printed with
+// the instrumented file at their original positions, right before the
+// nodes they apply to
+func KeepDirectives(file *ast.File) {
+	preambles := make(map[*ast.CommentGroup]bool)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || len(gen.Specs) != 1 {
+			continue
+		}
+		if spec, ok := gen.Specs[0].(*ast.ImportSpec); ok && spec.Path.Value == `"C"` && gen.Doc != nil {
+			preambles[gen.Doc] = true
+		}
+	}
+
+	// never nil, or the printer takes comments of nodes instead
+	comments := make([]*ast.CommentGroup, 0)
+	for _, group := range file.Comments {
+		if preambles[group] {
+			comments = append(comments, group)
+			continue
+		}
+		var list []*ast.Comment
+		for _, c := range group.List {
+			if isDirective(c.Text) {
+				list = append(list, c)
+			}
+		}
+		if len(list) > 0 {
+			comments = append(comments, &ast.CommentGroup{List: list})
+		}
+	}
+	file.Comments = comments
+}
+
+func isDirective(text string) bool {
+	return strings.HasPrefix(text, "//go:") ||
+		strings.HasPrefix(text, "// +build ") ||
+		strings.HasPrefix(text, "//export ")
+}
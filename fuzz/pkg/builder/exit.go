@@ -0,0 +1,181 @@
+package builder
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// fatal functions of packages, by import path
+var fatalFuncs = map[string]bool{
+	"log.Fatal":                          true,
+	"log.Fatalf":                         true,
+	"log.Fatalln":                        true,
+	"github.com/pingcap/log.Fatal":       true,
+	"github.com/sirupsen/logrus.Fatal":   true,
+	"github.com/sirupsen/logrus.Fatalf":  true,
+	"github.com/sirupsen/logrus.Fatalln": true,
+}
+
+// packages of loggers, by import path
+var loggerPkgs = map[string]bool{
+	"log":                                  true,
+	"go.uber.org/zap":                      true,
+	"github.com/pingcap/log":               true,
+	"github.com/pingcap/tidb/util/logutil": true,
+	"github.com/pingcap/tidb/pkg/util/logutil": true,
+	"github.com/sirupsen/logrus":               true,
+}
+
+// fatal methods of loggers, e.g. `logutil.BgLogger().Fatal(...)` of zap;
+// without type checking they are hooked only if the receiver is got from
+// a logger package, or from variables, fields and functions of the file
+// holding loggers, so `t.Fatal()` of tests is not
+var fatalMethods = map[string]bool{
+	"Fatal":   true,
+	"Fatalf":  true,
+	"Fatalw":  true,
+	"Fatalln": true,
+}
+
+// os.Exit(code) ==> os.Exit(ReportExit(id, code))
+// log.Fatal(...) ==> ReportFatal(id, "log.Fatal"); log.Fatal(...)
+//
+// the arguments of fatal calls are not evaluated twice, their messages
+// are in tidb logs anyway
+func (v *Visitor) hookExits(stmts []ast.Stmt) []ast.Stmt {
+	res := make([]ast.Stmt, 0, len(stmts))
+	for _, s := range stmts {
+		if call, ok := exitCall(s); ok {
+			sel := call.Fun.(*ast.SelectorExpr)
+			pkg, isPkg := sel.X.(*ast.Ident)
+			switch {
+			case isPkg && v.imports[pkg.Name] == "os" && sel.Sel.Name == "Exit" && len(call.Args) == 1:
+				call.Args[0] = makeDepCallExpr("ReportExit", makeIntLit(genBlockId()), call.Args[0])
+				v.setChanged()
+			case isPkg && v.imports[pkg.Name] != "":
+				if fatalFuncs[v.imports[pkg.Name]+"."+sel.Sel.Name] {
+					res = append(res, makeDepCall("ReportFatal", makeIntLit(genBlockId()), makeStringLit(types.ExprString(sel))))
+					v.setChanged()
+				}
+			case fatalMethods[sel.Sel.Name] && isLogger(sel.X, v.imports, v.loggers):
+				res = append(res, makeDepCall("ReportFatal", makeIntLit(genBlockId()), makeStringLit(types.ExprString(sel))))
+				v.setChanged()
+			}
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// exitCall returns the call of `x.f(...)` statements
+func exitCall(s ast.Stmt) (*ast.CallExpr, bool) {
+	expr, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return nil, false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok {
+		return nil, false
+	}
+	_, ok = call.Fun.(*ast.SelectorExpr)
+	return call, ok
+}
+
+// isLogger tells if expr is got from a logger package, like
+// `logutil.Logger(ctx).With(...)`, or from a name in loggers, like `l`,
+// `s.logger` and `s.getLogger()`
+func isLogger(expr ast.Expr, imports map[string]string, loggers map[string]bool) bool {
+	if loggerPkgs[imports[rootIdent(expr)]] {
+		return true
+	}
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return loggers[e.Name]
+		case *ast.SelectorExpr:
+			if loggers[e.Sel.Name] {
+				return true
+			}
+			expr = e.X
+		case *ast.CallExpr:
+			expr = e.Fun
+		default:
+			return false
+		}
+	}
+}
+
+// fileLoggers returns the names of variables, fields and functions of the
+// file holding loggers: declared with types of logger packages, assigned
+// from loggers, or returning them, e.g. `l` of `l := logutil.BgLogger()` and
+// `logger` of `struct { logger *zap.Logger }`. Names are not scoped, which
+// is fine for hooks only reporting fatal calls
+func fileLoggers(f *ast.File, imports map[string]string) map[string]bool {
+	res := make(map[string]bool)
+	fromLogger := func(expr ast.Expr) bool {
+		if star, ok := expr.(*ast.StarExpr); ok {
+			expr = star.X
+		}
+		return expr != nil && isLogger(expr, imports, res)
+	}
+	addIdents := func(idents []*ast.Ident) {
+		for _, ident := range idents {
+			res[ident.Name] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			if fromLogger(n.Type) {
+				addIdents(n.Names)
+			}
+		case *ast.ValueSpec:
+			if fromLogger(n.Type) {
+				addIdents(n.Names)
+			}
+			for i, val := range n.Values {
+				if i < len(n.Names) && fromLogger(val) {
+					res[n.Names[i].Name] = true
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) != len(n.Rhs) {
+				break
+			}
+			for i, val := range n.Rhs {
+				if !fromLogger(val) {
+					continue
+				}
+				switch lhs := n.Lhs[i].(type) {
+				case *ast.Ident:
+					res[lhs.Name] = true
+				case *ast.SelectorExpr:
+					res[lhs.Sel.Name] = true
+				}
+			}
+		case *ast.FuncDecl:
+			if results := n.Type.Results; results != nil && len(results.List) == 1 && fromLogger(results.List[0].Type) {
+				res[n.Name.Name] = true
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// rootIdent returns the name of the leftmost identifier of selectors and
+// calls like `logutil.Logger(ctx).With(...)`, or "" if there is none
+func rootIdent(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return e.Name
+		case *ast.SelectorExpr:
+			expr = e.X
+		case *ast.CallExpr:
+			expr = e.Fun
+		default:
+			return ""
+		}
+	}
+}
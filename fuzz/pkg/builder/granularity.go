@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"fmt"
+	"go/token"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+type Granularity uint8
+
+const (
+	BlockGranularity   Granularity = iota // counter in every basic block
+	FuncGranularity                       // counter at function entry only
+	SampledGranularity                    // counter in a deterministic subset of blocks
+	SkipGranularity                       // no counter, only for functions in Visitor.Funcs
+)
+
+func (g Granularity) String() string {
+	switch g {
+	case BlockGranularity:
+		return "block"
+	case FuncGranularity:
+		return "func"
+	case SampledGranularity:
+		return "sample"
+	case SkipGranularity:
+		return "skip"
+	}
+	return fmt.Sprintf("Granularity(%d)", uint8(g))
+}
+
+func ParseGranularity(str string) (Granularity, error) {
+	switch str {
+	case "block":
+		return BlockGranularity, nil
+	case "func":
+		return FuncGranularity, nil
+	case "sample":
+		return SampledGranularity, nil
+	}
+	return BlockGranularity, fmt.Errorf("unknown granularity %q, should be block, func or sample", str)
+}
+
+// GranularityRules selects the granularity of every package by the longest
+// matched package directory, relative to the root of tidb source code
+type GranularityRules struct {
+	Default Granularity
+	pkgs    map[string]Granularity
+}
+
+// ParseGranularityRules parses rules like `executor/aggfuncs=func,util/chunk=sample`
+func ParseGranularityRules(def Granularity, str string) (*GranularityRules, error) {
+	rules := &GranularityRules{Default: def, pkgs: make(map[string]Granularity)}
+	if str == "" {
+		return rules, nil
+	}
+	for _, rule := range strings.Split(str, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("bad granularity rule %q, should be `pkg=granularity`", rule)
+		}
+		g, err := ParseGranularity(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		rules.pkgs[filepath.Clean(kv[0])] = g
+	}
+	return rules, nil
+}
+
+// For returns the granularity of the package in dir, which is relative to root
+func (r *GranularityRules) For(dir string) Granularity {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if g, ok := r.pkgs[dir]; ok {
+			return g
+		}
+		if dir == "." || dir == string(filepath.Separator) {
+			return r.Default
+		}
+	}
+}
+
+// sampled decides whether the block at pos is instrumented in sampled
+// granularity; it only depends on the package path, file name and offset,
+// so every build of the same source instruments the same blocks, wherever
+// the target is checked out
+func (v *Visitor) sampled(pos token.Pos) bool {
+	if v.Granularity != SampledGranularity || v.SampleRate <= 1 {
+		return true
+	}
+	position := v.FSet.Position(pos)
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%s:%d", v.PkgPath, filepath.Base(position.Filename), position.Offset)
+	return h.Sum32()%uint32(v.SampleRate) == 0
+}
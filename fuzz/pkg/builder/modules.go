@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg"
+)
+
+// dependency modules are copied into it to be instrumented; `go ./...`
+// ignores directories beginning with `_`
+const VENDOR_MODULES_DIR = "_tidb_go_fuzz_mods"
+
+// Module is a dependency module copied into the tidb source code
+type Module struct {
+	Path    string
+	Version string
+	Dir     string // where it's copied to
+}
+
+// VendorModules copies the selected versions of modules from the module
+// cache (or their local replacements) into root, and replaces them in
+// go.mod, or go.work if tidb is built in workspace mode, with the copies
+func VendorModules(root string, paths []string) ([]*Module, error) {
+	var res []*Module
+	for _, modPath := range paths {
+		info, err := goModJSON(root, "list", "-m", "-json", modPath)
+		if err != nil {
+			return nil, err
+		}
+		if info.Dir == "" {
+			// not downloaded yet
+			if info, err = goModJSON(root, "mod", "download", "-json", modPath+"@"+info.Version); err != nil {
+				return nil, err
+			}
+		}
+
+		dst := filepath.Join(root, VENDOR_MODULES_DIR, filepath.FromSlash(modPath))
+		if err := pkg.Copy(info.Dir, dst); err != nil {
+			return nil, err
+		}
+		// files in the module cache are read-only
+		if err := makeWritable(dst); err != nil {
+			return nil, err
+		}
+		replace := fmt.Sprintf("-replace=%s=./%s", modPath, path.Join(VENDOR_MODULES_DIR, modPath))
+		// replacements in go.work override the ones in go.mod
+		edit := "mod"
+		if pkg.FileExists(filepath.Join(root, "go.work")) {
+			edit = "work"
+		}
+		if _, err := runGo(root, edit, "edit", replace); err != nil {
+			return nil, err
+		}
+		res = append(res, &Module{Path: modPath, Version: info.Version, Dir: dst})
+	}
+	return res, nil
+}
+
+type goModule struct {
+	Path    string
+	Version string
+	Dir     string
+}
+
+func goModJSON(dir string, args ...string) (*goModule, error) {
+	out, err := runGo(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	info := &goModule{}
+	if err := json.Unmarshal(out, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func runGo(dir string, args ...string) ([]byte, error) {
+	shellCmd := exec.Command("go", args...)
+	shellCmd.Dir = dir
+	buf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	shellCmd.Stdout = buf
+	shellCmd.Stderr = errBuf
+	if err := shellCmd.Run(); err != nil {
+		return nil, fmt.Errorf("go %v error %v\n%s", args, err, errBuf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+func makeWritable(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chmod(path, info.Mode()|0200)
+	})
+}
@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVendorModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-modules")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"db/go.mod":        "module example.com/db\n\ngo 1.13\n\nrequire example.com/parser v0.0.0\n\nreplace example.com/parser => ../parser\n",
+		"db/main.go":       "package main\n\nimport \"example.com/parser\"\n\nfunc main() { parser.Parse() }\n",
+		"parser/go.mod":    "module example.com/parser\n\ngo 1.13\n",
+		"parser/parser.go": "package parser\n\nfunc Parse() {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(content), 0444))
+	}
+
+	root := filepath.Join(dir, "db")
+	assert.Equal(t, nil, os.Chmod(filepath.Join(root, "go.mod"), 0644))
+	modules, err := VendorModules(root, []string{"example.com/parser"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(modules))
+	assert.Equal(t, "example.com/parser", modules[0].Path)
+	assert.Equal(t, filepath.Join(root, VENDOR_MODULES_DIR, "example.com", "parser"), modules[0].Dir)
+
+	// the copy is writable for instrumenting
+	copied := filepath.Join(modules[0].Dir, "parser.go")
+	assert.Equal(t, nil, ioutil.WriteFile(copied, []byte("package parser\n\nfunc Parse() { println() }\n"), 0644))
+
+	goMod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(goMod), "replace example.com/parser => ./"+VENDOR_MODULES_DIR+"/example.com/parser")
+	out, err := runGo(root, "list", "-m", "-f", "{{.Dir}}", "example.com/parser")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, modules[0].Dir+"\n", string(out))
+
+	_, err = VendorModules(root, []string{"example.com/not-exist"})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestVendorModulesWorkspace(t *testing.T) {
+	// -mod can't be set in workspace mode
+	t.Setenv("GOFLAGS", "")
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-modules")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.work":          "go 1.21\n\nuse ./db\n",
+		"db/go.mod":        "module example.com/db\n\ngo 1.21\n\nrequire example.com/parser v0.0.0\n\nreplace example.com/parser => ../parser\n",
+		"db/main.go":       "package main\n\nimport \"example.com/parser\"\n\nfunc main() { parser.Parse() }\n",
+		"parser/go.mod":    "module example.com/parser\n\ngo 1.21\n",
+		"parser/parser.go": "package parser\n\nfunc Parse() {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	// tidb is the workspace root in workspace mode
+	modules, err := VendorModules(dir, []string{"example.com/parser"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(modules))
+
+	// the copy is replaced in go.work, which overrides go.mod
+	goWork, err := ioutil.ReadFile(filepath.Join(dir, "go.work"))
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(goWork), "example.com/parser => ./"+VENDOR_MODULES_DIR+"/example.com/parser")
+	goMod, err := ioutil.ReadFile(filepath.Join(dir, "db", "go.mod"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, files["db/go.mod"], string(goMod))
+	out, err := runGo(filepath.Join(dir, "db"), "list", "-m", "-f", "{{.Dir}}", "example.com/parser")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, modules[0].Dir+"\n", string(out))
+}
@@ -0,0 +1,173 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+const HOT_FUNCS_FILE = "tidb-go-fuzz-hot-funcs.txt"
+
+// HotFunc is a function with its self cpu time in a profile
+type HotFunc struct {
+	Name  string // like github.com/pingcap/tidb/util/chunk.(*Column).GetInt64
+	Flat  int64
+	Share float64 // of the flat time of all functions matching the prefixes
+}
+
+// closures are instrumented as a part of the function declaring them
+var closureSuffix = regexp.MustCompile(`(\.func\d+|\.\d+)+$`)
+
+// typeParams are dropped, since generic functions are named like F[...] in profiles
+var typeParams = regexp.MustCompile(`\[[^\[\]]*\]`)
+
+func normalizeFuncName(name string) string {
+	for typeParams.MatchString(name) {
+		name = typeParams.ReplaceAllString(name, "")
+	}
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+// LoadHotFuncs returns the hottest functions whose names start with one of
+// prefixes, until they take fraction of the flat time of all these functions
+func LoadHotFuncs(path string, prefixes []string, fraction float64) ([]HotFunc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.SampleType) == 0 {
+		return nil, fmt.Errorf("profile %s has no sample type", path)
+	}
+	// the last one is cpu nanoseconds in cpu profiles
+	idx := len(p.SampleType) - 1
+
+	flat := make(map[string]int64)
+	total := int64(0)
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 {
+			continue
+		}
+		// the first line is the innermost inlined function
+		fn := s.Location[0].Line[0].Function
+		if fn == nil {
+			continue
+		}
+		name := normalizeFuncName(fn.Name)
+		if !hasAnyPrefix(name, prefixes) {
+			continue
+		}
+		flat[name] += s.Value[idx]
+		total += s.Value[idx]
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	funcs := make([]HotFunc, 0, len(flat))
+	for name, val := range flat {
+		funcs = append(funcs, HotFunc{Name: name, Flat: val, Share: float64(val) / float64(total)})
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].Flat != funcs[j].Flat {
+			return funcs[i].Flat > funcs[j].Flat
+		}
+		return funcs[i].Name < funcs[j].Name
+	})
+	sum := 0.0
+	for i := range funcs {
+		if sum >= fraction {
+			return funcs[:i], nil
+		}
+		sum += funcs[i].Share
+	}
+	return funcs, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteHotFuncs lists the functions excluded by the profile
+func WriteHotFuncs(path string, funcs []HotFunc, g Granularity) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, fn := range funcs {
+		fmt.Fprintf(w, "%s\t%.2f%%\t%s\n", g, fn.Share*100, fn.Name)
+	}
+	return w.Flush()
+}
+
+// ModulePath reads the module path from go.mod in dir
+func ModulePath(dir string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("no module directive in %s", filepath.Join(dir, "go.mod"))
+}
+
+// PackagePath is the import path of the package in rel, relative to the
+// module root. Visitor names functions of main packages as `main.f` itself
+func PackagePath(module, rel string) string {
+	if rel == "." || rel == "" {
+		return module
+	}
+	return module + "/" + filepath.ToSlash(rel)
+}
+
+// FuncName is the name of a function declaration in profiles,
+// like pkg.F, pkg.T.M or pkg.(*T).M
+func FuncName(pkgPath string, decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return pkgPath + "." + decl.Name.Name
+	}
+	typ := decl.Recv.List[0].Type
+	star := false
+	if s, ok := typ.(*ast.StarExpr); ok {
+		star = true
+		typ = s.X
+	}
+	// drop type parameters of generic receivers
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	recv := "?"
+	if ident, ok := typ.(*ast.Ident); ok {
+		recv = ident.Name
+	}
+	if star {
+		recv = "(*" + recv + ")"
+	}
+	return pkgPath + "." + recv + "." + decl.Name.Name
+}
@@ -0,0 +1,129 @@
+package builder
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeProfile(t *testing.T, path string, flat map[string]int64) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+	}
+	id := uint64(1)
+	for name, val := range flat {
+		fn := &profile.Function{ID: id, Name: name}
+		loc := &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1, val}})
+		id++
+	}
+	f, err := os.Create(path)
+	assert.Equal(t, nil, err)
+	defer f.Close()
+	assert.Equal(t, nil, p.Write(f))
+}
+
+func TestLoadHotFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-profile")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cpu.pprof")
+	writeProfile(t, path, map[string]int64{
+		"github.com/pingcap/tidb/util/chunk.(*Column).GetInt64":        50,
+		"github.com/pingcap/tidb/util/codec.EncodeInt":                 20,
+		"github.com/pingcap/tidb/util/codec.EncodeInt.func1":           10,
+		"github.com/pingcap/tidb/executor.(*HashAggExec).Next.func2.1": 10,
+		"github.com/pingcap/tidb/session.(*session).ExecuteStmt":       10,
+		"runtime.mallocgc": 1000,
+	})
+
+	prefixes := []string{"github.com/pingcap/tidb/"}
+	funcs, err := LoadHotFuncs(path, prefixes, 0.5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(funcs))
+	assert.Equal(t, "github.com/pingcap/tidb/util/chunk.(*Column).GetInt64", funcs[0].Name)
+	assert.InDelta(t, 0.5, funcs[0].Share, 1e-9)
+
+	// closures are counted in their functions
+	funcs, err = LoadHotFuncs(path, prefixes, 0.7)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(funcs))
+	assert.Equal(t, "github.com/pingcap/tidb/util/codec.EncodeInt", funcs[1].Name)
+	assert.Equal(t, int64(30), funcs[1].Flat)
+
+	funcs, err = LoadHotFuncs(path, prefixes, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(funcs))
+	assert.Equal(t, "github.com/pingcap/tidb/executor.(*HashAggExec).Next", funcs[2].Name)
+
+	_, err = LoadHotFuncs(filepath.Join(dir, "not-exist"), prefixes, 1)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestFuncName(t *testing.T) {
+	src := `package chunk
+func F() {}
+func (c *Column) GetInt64() {}
+func (r Row) Len() {}
+func (l *List[T]) Push() {}
+func (m Map[K, V]) Get() {}
+`
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", src, 0)
+	assert.Equal(t, nil, err)
+
+	var names []string
+	for _, decl := range astFile.Decls {
+		names = append(names, FuncName("a/chunk", decl.(*ast.FuncDecl)))
+	}
+	assert.Equal(t, []string{"a/chunk.F", "a/chunk.(*Column).GetInt64", "a/chunk.Row.Len", "a/chunk.(*List).Push", "a/chunk.Map.Get"}, names)
+
+	assert.Equal(t, "a", PackagePath("a", "."))
+	assert.Equal(t, "a/util/chunk", PackagePath("a", filepath.Join("util", "chunk")))
+}
+
+func TestFuncsGranularity(t *testing.T) {
+	src := `package chunk
+func Hot() {
+	if true {
+		println()
+	}
+}
+func Cold() {
+	if true {
+		println()
+	}
+}
+`
+	instrument := func(funcs map[string]Granularity) string {
+		fset := token.NewFileSet()
+		astFile, err := parser.ParseFile(fset, "", src, 0)
+		assert.Equal(t, nil, err)
+		visitor := NewVisitorPtr(fset)
+		visitor.PkgPath = "a/chunk"
+		visitor.Funcs = funcs
+		ast.Walk(visitor, astFile)
+		return AstToBytes(astFile, fset).String()
+	}
+
+	out := instrument(nil)
+	assert.Equal(t, 4, strings.Count(out, "AddCount("))
+
+	out = instrument(map[string]Granularity{"a/chunk.Hot": SkipGranularity})
+	assert.Equal(t, 2, strings.Count(out, "AddCount("))
+	assert.Contains(t, out, "func Hot() {\n\tif true {\n\t\tprintln()")
+
+	out = instrument(map[string]Granularity{"a/chunk.Hot": FuncGranularity})
+	assert.Equal(t, 3, strings.Count(out, "AddCount("))
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// ReachableFuncs returns the functions of modules reachable from roots by
+// rapid type analysis, named like FuncName. Roots are named in the same way,
+// and they may be relative to the first module, which is in dir, e.g.
+// `session.(*session).ExecuteStmt`, but they can't be in main packages
+func ReachableFuncs(dir string, modules []string, roots []string) (map[string]bool, error) {
+	module := modules[0]
+	var names, patterns []string
+	for _, root := range roots {
+		if !strings.HasPrefix(root, module+".") && !strings.HasPrefix(root, module+"/") {
+			root = module + "/" + root
+		}
+		names = append(names, root)
+		patterns = append(patterns, rootPackage(root))
+	}
+	cfg := &packages.Config{Mode: packages.LoadAllSyntax, Dir: dir}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if n := packages.PrintErrors(pkgs); n > 0 {
+		return nil, fmt.Errorf("%d errors in loading packages", n)
+	}
+	// instances of generic functions are analyzed as their origins
+	prog, _ := ssautil.AllPackages(pkgs, ssa.InstantiateGenerics)
+	prog.Build()
+
+	byName := make(map[string]*ssa.Function)
+	for _, pkg := range prog.AllPackages() {
+		for _, member := range pkg.Members {
+			switch m := member.(type) {
+			case *ssa.Function:
+				byName[ssaFuncName(m)] = m
+			case *ssa.Type:
+				named, ok := m.Type().(*types.Named)
+				if !ok || named.TypeParams().Len() > 0 {
+					continue
+				}
+				mset := prog.MethodSets.MethodSet(types.NewPointer(named))
+				for i := 0; i < mset.Len(); i++ {
+					if fn := prog.MethodValue(mset.At(i)); fn != nil && fn.Synthetic == "" {
+						byName[ssaFuncName(fn)] = fn
+					}
+				}
+			}
+		}
+	}
+	var rootFuncs []*ssa.Function
+	for _, root := range names {
+		fn, ok := byName[root]
+		if !ok {
+			return nil, fmt.Errorf("root function %s is not found", root)
+		}
+		rootFuncs = append(rootFuncs, fn)
+	}
+	// package-level variables are initialized and init functions run before
+	// any root, e.g. registrations into maps of builders
+	for _, pkg := range prog.AllPackages() {
+		if fn := pkg.Func("init"); fn != nil {
+			rootFuncs = append(rootFuncs, fn)
+		}
+	}
+
+	// only functions of the module are instrumented
+	res := make(map[string]bool)
+	for fn := range rta.Analyze(rootFuncs, false).Reachable {
+		name := ssaFuncName(fn)
+		if strings.HasPrefix(name, "main.") || hasAnyPrefix(name, ModulePrefixes(modules)) {
+			res[name] = true
+		}
+	}
+	return res, nil
+}
+
+// ModulePrefixes are prefixes of names of functions in modules
+func ModulePrefixes(modules []string) []string {
+	var res []string
+	for _, module := range modules {
+		res = append(res, module+".", module+"/")
+	}
+	return res
+}
+
+// github.com/pingcap/tidb/session.(*session).ExecuteStmt => github.com/pingcap/tidb/session
+func rootPackage(root string) string {
+	slash := strings.LastIndex(root, "/")
+	return root[:slash+1+strings.Index(root[slash+1:], ".")]
+}
+
+// ssaFuncName is FuncName of the declaration of fn; closures are named by
+// their enclosing functions, since they are instrumented together
+func ssaFuncName(fn *ssa.Function) string {
+	for fn.Parent() != nil {
+		fn = fn.Parent()
+	}
+	if fn.Origin() != nil {
+		fn = fn.Origin()
+	}
+	obj, ok := fn.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil {
+		// wrappers and package initializers
+		return ""
+	}
+	pkgPath := obj.Pkg().Path()
+	if obj.Pkg().Name() == "main" {
+		pkgPath = "main"
+	}
+	recv := obj.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return pkgPath + "." + obj.Name()
+	}
+	typ := recv.Type()
+	star := false
+	if p, ok := typ.(*types.Pointer); ok {
+		star = true
+		typ = p.Elem()
+	}
+	name := "?"
+	if named, ok := typ.(*types.Named); ok {
+		name = named.Obj().Name()
+	}
+	if star {
+		name = "(*" + name + ")"
+	}
+	return pkgPath + "." + name + "." + obj.Name()
+}
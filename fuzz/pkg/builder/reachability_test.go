@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var reachabilityModule = map[string]string{
+	"go.mod": "module example.com/db\n\ngo 1.21\n",
+	"session/session.go": `package session
+
+import (
+	"example.com/db/executor"
+	"example.com/db/util"
+)
+
+type Session interface{ ExecuteStmt(sql string) int }
+
+type session struct{}
+
+func (s *session) ExecuteStmt(sql string) int {
+	var e executor.Executor = &executor.TableReader{}
+	util.NewSet[string]().Add(sql)
+	return e.Next() + helper(func() int { return 1 }) + util.Max(1, len(util.Sorted(nil)))
+}
+
+func helper(f func() int) int { return f() }
+
+func (s *session) Close() {}
+
+func backgroundWorker() { helper(nil) }
+`,
+	"executor/executor.go": `package executor
+
+type Executor interface{ Next() int }
+
+type TableReader struct{}
+
+func (e *TableReader) Next() int { return 0 }
+
+var builders = make(map[string]int)
+
+func init() { register("table") }
+
+func register(name string) { builders[name] = len(builders) }
+
+type IndexReader struct{}
+
+func (e IndexReader) Next() int { return 1 }
+
+func Dead() {}
+`,
+	"util/util.go": `package util
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+type Set[T comparable] struct{ m map[T]bool }
+
+func NewSet[T comparable]() *Set[T] { return &Set[T]{m: make(map[T]bool)} }
+
+func (s *Set[T]) Add(v T) { s.m[v] = true }
+
+func (s *Set[T]) Len() int { return len(s.m) }
+
+func Max[T int | int64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var sorts int64
+
+func Sorted(a []int) []int {
+	atomic.AddInt64(&sorts, 1)
+	sort.Ints(a)
+	return a
+}
+`,
+}
+
+func TestReachableFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-reachability")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	for name, content := range reachabilityModule {
+		path := filepath.Join(dir, name)
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	funcs, err := ReachableFuncs(dir, []string{"example.com/db"}, []string{"session.(*session).ExecuteStmt"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]bool{
+		"example.com/db/session.(*session).ExecuteStmt": true,
+		"example.com/db/session.helper":                 true,
+		"example.com/db/executor.(*TableReader).Next":   true,
+		"example.com/db/executor.init":                  true,
+		"example.com/db/executor.register":              true,
+		"example.com/db/util.NewSet":                    true,
+		"example.com/db/util.(*Set).Add":                true,
+		"example.com/db/util.Max":                       true,
+		"example.com/db/util.Sorted":                    true,
+	}, funcs)
+
+	_, err = ReachableFuncs(dir, []string{"example.com/db"}, []string{"session.(*session).NotExist"})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRootPackage(t *testing.T) {
+	assert.Equal(t, "github.com/pingcap/tidb/session", rootPackage("github.com/pingcap/tidb/session.(*session).ExecuteStmt"))
+	assert.Equal(t, "github.com/pingcap/tidb/util/codec", rootPackage("github.com/pingcap/tidb/util/codec.EncodeKey"))
+}
+
+func TestVisitorReachable(t *testing.T) {
+	src := reachabilityModule["executor/executor.go"]
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", src, 0)
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.PkgPath = "example.com/db/executor"
+	visitor.Reachable = map[string]bool{"example.com/db/executor.(*TableReader).Next": true}
+	ast.Walk(visitor, astFile)
+	out := AstToBytes(astFile, fset).String()
+	assert.Equal(t, 1, strings.Count(out, "AddCount("))
+	assert.Contains(t, out, "func (e IndexReader) Next() int\t{ return 1 }")
+}
@@ -0,0 +1,20 @@
+package builder
+
+import "go/ast"
+
+// without type checking we can't tell whether it's the builtin recover,
+// but the chance of shadowing it is as small as panic
+func isRecover(t *ast.CallExpr) bool {
+	ident, ok := t.Fun.(*ast.Ident)
+	return ok && ident.Name == "recover" && len(t.Args) == 0
+}
+
+// recover() ==> ReportRecover(id, recover())
+//
+// recover is still called by the deferred function directly,
+// so it keeps working. t is rewritten in place
+func (v *Visitor) instrumentRecover(t *ast.CallExpr) {
+	call := makeDepCallExpr("ReportRecover", makeIntLit(genBlockId()), &ast.CallExpr{Fun: t.Fun})
+	t.Fun, t.Args = call.Fun, call.Args
+	v.setChanged()
+}
@@ -9,15 +9,37 @@ import (
 	"github.com/Illyrix/tidb-go-fuzz/dep/types"
 )
 
+type EdgeMode uint8
+
+const (
+	StaticEdge  EdgeMode = iota // edge from the lexical parent block is baked in
+	DynamicEdge                 // block records its own id, runtime computes the edge
+)
+
 type Visitor struct {
 	// blockIds []types.BlockIdType // current block id stack
-	// blocks   []*types.Block      // all blocks in this file (unordered)
+	blocks *[]*types.Block // all blocks in this file (unordered); shared by clones
 
 	// the outer block is 0x0000
 	parentBlockId types.BlockIdType
 
-	FSet    *token.FileSet
-	Changed bool
+	FSet        *token.FileSet
+	Changed     bool
+	Mode        EdgeMode
+	CallContext bool // track function entry/exit for context sensitive edges
+	TraceRoute  bool // record routes of goroutines, which need to be known
+	CmpLog      bool // trace operands of comparisons with literals
+	Recover     bool // report panics recovered by recover()
+	HookExit    bool // report os.Exit and fatal logs before they exit
+	Granularity Granularity
+	SampleRate  int                    // 1 of SampleRate blocks is instrumented in sampled granularity
+	PkgPath     string                 // import path of the package, to name functions
+	Funcs       map[string]Granularity // granularity of functions named by FuncName, overriding Granularity
+	Reachable   map[string]bool        // if not nil, only functions in it are instrumented
+
+	imports map[string]string // local name => import path of current file
+	loggers map[string]bool   // names holding loggers in current file, see fileLoggers
+	root    *Visitor          // clones report Changed to the root visitor
 }
 
 func init() {
@@ -29,24 +51,66 @@ func (v *Visitor) Clone() *Visitor {
 	if v == nil {
 		return nil
 	}
+	root := v.root
+	if root == nil {
+		root = v
+	}
 	return &Visitor{
+		blocks:        v.blocks,
 		parentBlockId: v.parentBlockId,
 		FSet:          v.FSet,
 		Changed:       v.Changed,
+		Mode:          v.Mode,
+		CallContext:   v.CallContext,
+		TraceRoute:    v.TraceRoute,
+		CmpLog:        v.CmpLog,
+		Recover:       v.Recover,
+		HookExit:      v.HookExit,
+		Granularity:   v.Granularity,
+		SampleRate:    v.SampleRate,
+		PkgPath:       v.PkgPath,
+		Funcs:         v.Funcs,
+		Reachable:     v.Reachable,
+		imports:       v.imports,
+		loggers:       v.loggers,
+		root:          root,
+	}
+}
+
+// counters may be only added by clones, e.g. in sampled granularity
+func (v *Visitor) setChanged() {
+	v.Changed = true
+	if v.root != nil {
+		v.root.Changed = true
 	}
 }
 
 func NewVisitorPtr(fset *token.FileSet) *Visitor {
 	return &Visitor{
+		blocks:        &[]*types.Block{},
 		FSet:          fset,
 		Changed:       false,
 		parentBlockId: 0,
 	}
 }
 
+// Blocks returns where counters are injected by this visitor and its clones
+func (v *Visitor) Blocks() []*types.Block {
+	return *v.blocks
+}
+
 func (v *Visitor) Visit(n ast.Node) ast.Visitor {
 	// fmt.Printf("%T\n", n)
 	switch t := n.(type) {
+	case *ast.File:
+		v.imports = fileImports(t)
+		if v.HookExit {
+			v.loggers = fileLoggers(t, v.imports)
+		}
+		if t.Name.Name == "main" {
+			// functions in main packages are named like main.f by the runtime
+			v.PkgPath = "main"
+		}
 	case *ast.GenDecl:
 		if t.Tok != token.VAR {
 			return nil
@@ -56,12 +120,42 @@ func (v *Visitor) Visit(n ast.Node) ast.Visitor {
 			// init function only always run once
 			return nil
 		}
+		name := FuncName(v.PkgPath, t)
+		if v.Reachable != nil && !v.Reachable[name] {
+			return nil
+		}
+		if g, ok := v.Funcs[name]; ok && g != v.Granularity {
+			if g == SkipGranularity {
+				return nil
+			}
+			v = v.Clone()
+			v.Granularity = g
+		}
+		if v.CallContext && t.Body != nil {
+			// func f() { ... }
+			// ==>
+			// func f() { EnterFunc(id); defer ExitFunc(); ... }
+			v.setChanged()
+			id := genBlockId()
+			v.addBlock(t.Pos(), t.End(), id).Func = name
+			t.Body.List = append(makeEnterFuncNodes(id), t.Body.List...)
+		}
+		if v.Granularity == FuncGranularity && t.Body != nil {
+			v.addFuncCounter(t.Body)
+		}
+	case *ast.FuncLit:
+		if v.Granularity == FuncGranularity {
+			v.addFuncCounter(t.Body)
+		}
 	case *ast.SwitchStmt:
 		// Same as TypeSwitchStmt
 		// Don't annotate an empty switch - creates a syntax error.
 		if t.Body == nil || len(t.Body.List) == 0 {
 			return nil
 		}
+		if v.CmpLog {
+			v.instrumentSwitch(t)
+		}
 		hasDefault := false
 		for _, s := range t.Body.List {
 			if cas, ok := s.(*ast.CaseClause); ok && cas.List == nil {
@@ -122,6 +216,16 @@ func (v *Visitor) Visit(n ast.Node) ast.Visitor {
 		ast.Walk(v, t.Else)
 		return nil
 	case *ast.BlockStmt:
+		if v.CmpLog {
+			hoistSwitchInits(t.List)
+		}
+		if v.Granularity == FuncGranularity {
+			// counters are added at function entries
+			if v.HookExit {
+				t.List = v.hookExits(t.List)
+			}
+			return v
+		}
 		if len(t.List) > 0 {
 			switch t.List[0].(type) {
 			case *ast.CaseClause: // switch
@@ -140,19 +244,55 @@ func (v *Visitor) Visit(n ast.Node) ast.Visitor {
 		}
 		var blockId types.BlockIdType
 		blockId, t.List = v.addCounters(t.Lbrace, t.Rbrace+1, t.List, true) // +1 to step past closing brace.
+		// after counters are added, since the hooks have no position
+		if v.HookExit {
+			t.List = v.hookExits(t.List)
+		}
 		cloned := v.Clone()
 		cloned.parentBlockId = blockId
 		return cloned
+	case *ast.CaseClause:
+		if v.HookExit {
+			t.Body = v.hookExits(t.Body)
+		}
+	case *ast.CommClause:
+		if v.HookExit {
+			t.Body = v.hookExits(t.Body)
+		}
+	case *ast.CallExpr:
+		if v.Recover && isRecover(t) {
+			// the rewritten call must not be walked again
+			v.instrumentRecover(t)
+			return nil
+		}
+		if v.CmpLog {
+			v.instrumentCmpCall(t)
+		}
 	case *ast.BinaryExpr:
-		if t.Op == token.LAND || t.Op == token.LOR {
+		if v.CmpLog && isComparison(t.Op) && v.instrumentCmp(t) {
+			return nil
+		}
+		// in function granularity there is no counter for the right operand
+		if (t.Op == token.LAND || t.Op == token.LOR) && v.Granularity != FuncGranularity {
 			// x || y ==> x || (func() bool {return y})()
 			// see https://github.com/dvyukov/go-fuzz/blob/ea4a322d67f6e874238a8a7ab28e95a6d6675190/go-fuzz-build/cover.go#L607
-			t.Y = &ast.CallExpr{
+			result := t.Y
+			if v.CmpLog {
+				// comparisons in y are rewritten into calls returning bool, so
+				// x || (func() bool {return bool(y)})() == true keeps it working
+				// with named bool types of x
+				result = &ast.CallExpr{Fun: ast.NewIdent("bool"), Args: []ast.Expr{t.Y}}
+			}
+			call := &ast.CallExpr{
 				Fun: &ast.FuncLit{
 					Type: &ast.FuncType{Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("bool")}}}},
-					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{t.Y}}}},
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{result}}}},
 				},
 			}
+			t.Y = call
+			if v.CmpLog {
+				t.Y = &ast.BinaryExpr{X: call, Op: token.EQL, Y: ast.NewIdent("true")}
+			}
 		}
 	}
 	return v
@@ -164,9 +304,10 @@ func (v *Visitor) addCounters(pos, blockEnd token.Pos, stmts []ast.Stmt, extendT
 	// ==>
 	// { ... BLOCK1 } if 1>0 { ... BLOCK2 } { ... BLOCK3 }
 
-	v.Changed = true
-
 	if len(stmts) == 0 {
+		if !v.sampled(pos) {
+			return v.parentBlockId, stmts
+		}
 		bId := genBlockId()
 		return bId, []ast.Stmt{v.newCounter(pos, blockEnd, v.parentBlockId, bId)}
 	}
@@ -189,7 +330,7 @@ func (v *Visitor) addCounters(pos, blockEnd token.Pos, stmts []ast.Stmt, extendT
 		if extendToClosingBrace {
 			end = blockEnd
 		}
-		if pos != end { // Can have no source to cover if e.g. blocks abut.
+		if pos != end && v.sampled(pos) { // Can have no source to cover if e.g. blocks abut.
 			bId := genBlockId()
 			list = append(list, v.newCounter(pos, end, lastBId, bId))
 			lastBId = bId
@@ -205,16 +346,47 @@ func (v *Visitor) addCounters(pos, blockEnd token.Pos, stmts []ast.Stmt, extendT
 	return lastBId, list
 }
 
+// func f() { ... } ==> func f() { COUNTER; ... }
+func (v *Visitor) addFuncCounter(body *ast.BlockStmt) {
+	bId := genBlockId()
+	body.List = append([]ast.Stmt{v.newCounter(body.Lbrace, body.Rbrace+1, v.parentBlockId, bId)}, body.List...)
+}
+
 // Warn: its implementation relates to defination of BlockIdType
 func genBlockId() types.BlockIdType {
 	return types.BlockIdType(rand.Uint32() >> 16)
 }
 
 func (v *Visitor) newCounter(pos, end token.Pos, src, dst types.BlockIdType) ast.Stmt {
-	// do not log where this block attaches
+	// the import is unused if no counter is added, e.g. all blocks are not sampled
+	v.setChanged()
+	// blocks added by the builder, like an empty default clause, have no position
+	if pos.IsValid() && end.IsValid() {
+		v.addBlock(pos, end, dst)
+	}
+	if v.Mode == DynamicEdge {
+		return makeBlockNode(dst)
+	}
+	if v.CallContext || v.TraceRoute {
+		// the runtime needs to know which goroutine to get its context or route
+		return makeDepCall("AddCount", makeIntLit(src), makeIntLit(dst))
+	}
 	return makeCountNode(src, dst)
 }
 
+// addBlock records the source range of id into the block map
+func (v *Visitor) addBlock(pos, end token.Pos, id types.BlockIdType) *types.Block {
+	start, stop := v.FSet.Position(pos), v.FSet.Position(end)
+	block := &types.Block{
+		Id:    id,
+		File:  start.Filename,
+		Start: types.CharPosition{Line: uint32(start.Line), Column: uint32(start.Column)},
+		End:   types.CharPosition{Line: uint32(stop.Line), Column: uint32(stop.Column)},
+	}
+	*v.blocks = append(*v.blocks, block)
+	return block
+}
+
 func (v *Visitor) statementBoundary(s ast.Stmt) token.Pos {
 	switch s := s.(type) {
 	case *ast.BlockStmt:
@@ -349,13 +521,13 @@ func (v *Visitor) AddImportDecl(aFile *ast.File) {
 		if gDecl, ok := decl.(*ast.GenDecl); ok {
 			if gDecl.Tok == token.IMPORT {
 				hasImports = true
-				gDecl.Specs = append(gDecl.Specs, &ast.ImportSpec{
-					Path: &ast.BasicLit{Kind: token.STRING,
-						Value: "\"" + FUZZ_DEP_IMPORT_NAME + "\""},
-					Name: &ast.Ident{
-						Name: FUZZ_DEP_IMPORT_AS,
-					},
-				})
+				// positioned after the last import, so comments after the
+				// declaration are not printed in it
+				end := gDecl.End()
+				if !gDecl.Rparen.IsValid() {
+					gDecl.Lparen, gDecl.Rparen = gDecl.Specs[0].Pos(), end
+				}
+				gDecl.Specs = append(gDecl.Specs, makeDepImport(end))
 				break
 			}
 		}
@@ -364,16 +536,22 @@ func (v *Visitor) AddImportDecl(aFile *ast.File) {
 	if !hasImports {
 		newDecl := make([]ast.Decl, 0)
 		newDecl = append(newDecl, &ast.GenDecl{
-			Tok: token.IMPORT,
-			Specs: []ast.Spec{&ast.ImportSpec{
-				Path: &ast.BasicLit{Kind: token.STRING,
-					Value: "\"" + FUZZ_DEP_IMPORT_NAME + "\""},
-				Name: &ast.Ident{
-					Name: FUZZ_DEP_IMPORT_AS,
-				},
-			}},
+			TokPos: aFile.Name.End(),
+			Tok:    token.IMPORT,
+			Specs:  []ast.Spec{makeDepImport(aFile.Name.End())},
 		})
 		newDecl = append(newDecl, aFile.Decls...)
 		aFile.Decls = newDecl
 	}
 }
+
+func makeDepImport(pos token.Pos) *ast.ImportSpec {
+	return &ast.ImportSpec{
+		Path: &ast.BasicLit{ValuePos: pos, Kind: token.STRING,
+			Value: "\"" + FUZZ_DEP_IMPORT_NAME + "\""},
+		Name: &ast.Ident{
+			NamePos: pos,
+			Name:    FUZZ_DEP_IMPORT_AS,
+		},
+	}
+}
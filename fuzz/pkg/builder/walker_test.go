@@ -1,9 +1,18 @@
 package builder
 
 import (
+	"bytes"
+	"fmt"
 	"go/ast"
+	"go/importer"
 	"go/parser"
+	"go/printer"
 	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -85,7 +94,7 @@ func TestAddCounters(t *testing.T) {
 
 		visitor.AddImportDecl(astFile)
 
-		_ := AstToBytes(astFile, fset)
+		_ = AstToBytes(astFile, fset)
 		// need to check manually
 
 	})
@@ -180,3 +189,526 @@ func main() {
 	defer Function3()
 }
 `
+
+func TestVisitorBlocks(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "test.go", complexCode, parser.ParseComments)
+
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	ast.Walk(visitor, astFile)
+
+	blocks := visitor.Blocks()
+	assert.NotEmpty(t, blocks)
+	for _, b := range blocks {
+		assert.Equal(t, "test.go", b.File)
+		assert.True(t, b.Start.Line > 0 && b.Start.Line <= b.End.Line)
+	}
+}
+
+func TestDynamicEdge(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", complexCode, parser.ParseComments)
+
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.Mode = DynamicEdge
+	ast.Walk(visitor, astFile)
+
+	out := AstToBytes(astFile, fset).String()
+	assert.Contains(t, out, "__tidb_go_fuzz_dep.AddBlock(")
+	assert.NotContains(t, out, "AddCount(")
+}
+
+func TestCallContext(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", complexCode, parser.ParseComments)
+
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.CallContext = true
+	visitor.PkgPath = "example.com/test"
+	ast.Walk(visitor, astFile)
+	visitor.AddImportDecl(astFile)
+
+	out := AstToBytes(astFile, fset).String()
+	// Function2, Function3 and main
+	assert.Equal(t, 3, strings.Count(out, "__tidb_go_fuzz_dep.EnterFunc("))
+	assert.Equal(t, 3, strings.Count(out, "defer __tidb_go_fuzz_dep.ExitFunc()"))
+	assert.Contains(t, out, "__tidb_go_fuzz_dep.AddCount(")
+	assert.NotContains(t, out, "GetTraceTable()")
+
+	_, err = parser.ParseFile(token.NewFileSet(), "", out, 0)
+	assert.Equal(t, nil, err)
+
+	// ids of EnterFunc are in the block map with the functions
+	var funcs []string
+	for _, b := range visitor.Blocks() {
+		if b.Func != "" {
+			funcs = append(funcs, b.Func)
+			assert.Contains(t, out, fmt.Sprintf("__tidb_go_fuzz_dep.EnterFunc(%d)", b.Id))
+		}
+	}
+	assert.ElementsMatch(t, []string{"example.com/test.Function2", "example.com/test.Function3", "example.com/test.main"}, funcs)
+
+	dir, err := ioutil.TempDir("", "blockmap")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, BLOCK_MAP_FILE)
+	assert.Equal(t, nil, WriteBlockMap(path, dir, visitor.Blocks()))
+	content, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	assert.Regexp(t, `(?m)^\d+\t:\d+\.\d+,\d+\.\d+\tfunc example\.com/test\.Function2$`, string(content))
+	assert.Equal(t, len(visitor.Blocks()), strings.Count(string(content), "\n"))
+}
+
+func TestTraceRouteCounter(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", complexCode, parser.ParseComments)
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.TraceRoute = true
+	ast.Walk(visitor, astFile)
+	visitor.AddImportDecl(astFile)
+
+	// routes are per goroutine, so counters go through the dep package
+	out := AstToBytes(astFile, fset).String()
+	assert.Contains(t, out, "__tidb_go_fuzz_dep.AddCount(")
+	assert.NotContains(t, out, "GetTraceTable()")
+	assert.NotContains(t, out, "EnterFunc(")
+}
+
+const cmpCode = `
+package test3
+
+import (
+	"bytes"
+	str "strings"
+)
+
+type Kind string
+
+type Flag bool
+
+func Compare(k Kind, n uint8, b []byte, name string) Flag {
+	var f Flag = k == "select" || n > 0xfe
+	switch name {
+	case "tidb_mem_quota_query", "sql_mode":
+		f = !f
+	case string(k):
+	}
+	switch l := len(b); l {
+	case 0:
+		f = !f
+	}
+	if str.EqualFold(name, "ANSI") && bytes.Equal(b, []byte("x")) {
+		return 'a' == n
+	}
+	return f && len(name) != -1
+}
+`
+
+func TestCmpLog(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", cmpCode, parser.ParseComments)
+
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.CmpLog = true
+	ast.Walk(visitor, astFile)
+	visitor.AddImportDecl(astFile)
+
+	out := AstToBytes(astFile, fset).String()
+	assert.Equal(t, 7, strings.Count(out, "__tidb_go_fuzz_dep.TraceCmp("))
+	for _, expected := range []string{`"select")`, `"254")`, `"tidb_mem_quota_query")`, `"sql_mode")`, `"0")`, `"97")`, `"-1")`} {
+		assert.Contains(t, out, expected)
+	}
+	assert.Contains(t, out, "switch __tidb_go_fuzz_tag := name; {")
+	assert.Contains(t, out, "switch __tidb_go_fuzz_tag := l; {")
+	assert.Contains(t, out, "str.EqualFold(__tidb_go_fuzz_dep.TraceEqualFold(")
+	assert.Contains(t, out, "bytes.Equal(__tidb_go_fuzz_dep.TraceBytesEqual(")
+
+	assert.Contains(t, out, "return bool(")
+	assert.Contains(t, out, "}() == true")
+
+	// rewritten comparisons keep their types, like the named Flag
+	fset = token.NewFileSet()
+	astFile, err = parser.ParseFile(fset, "", out, 0)
+	if assert.Equal(t, nil, err, out) {
+		conf := types.Config{Importer: importer.ForCompiler(token.NewFileSet(), "source", nil)}
+		_, err = conf.Check("test3", fset, []*ast.File{astFile}, nil)
+		assert.Equal(t, nil, err, out)
+	}
+
+	// the right operands of && and || are kept as is without cmplog
+	astFile, err = parser.ParseFile(fset, "", cmpCode, parser.ParseComments)
+	assert.Equal(t, nil, err)
+	ast.Walk(NewVisitorPtr(fset), astFile)
+	out = AstToBytes(astFile, fset).String()
+	assert.NotContains(t, out, "return bool(")
+	assert.NotContains(t, out, "== true")
+}
+
+func TestGranularity(t *testing.T) {
+	countIn := func(dir string, g Granularity, rate int) (int, string) {
+		fset := token.NewFileSet()
+		astFile, err := parser.ParseFile(fset, filepath.Join(dir, "test.go"), complexCode, parser.ParseComments)
+		assert.Equal(t, nil, err)
+
+		visitor := NewVisitorPtr(fset)
+		visitor.PkgPath = "example.com/test"
+		visitor.Granularity = g
+		visitor.SampleRate = rate
+		ast.Walk(visitor, astFile)
+		out := AstToBytes(astFile, fset).String()
+		return strings.Count(out, "AddCount("), out
+	}
+	count := func(g Granularity, rate int) (int, string) {
+		return countIn("/src/tidb", g, rate)
+	}
+
+	block, _ := count(BlockGranularity, 0)
+	fn, out := count(FuncGranularity, 0)
+	assert.Less(t, fn, block)
+	// 3 function declarations and 10 function literals
+	assert.Equal(t, 13, fn)
+	assert.Contains(t, out, "if a := Function2()(); a > 0 {\n\t\tfmt.Print(1)\n\t}")
+
+	sampled, _ := count(SampledGranularity, 3)
+	assert.Less(t, sampled, block)
+	again, sampledOut := count(SampledGranularity, 3)
+	assert.Equal(t, sampled, again)
+	// the same blocks are sampled in another checkout, regardless of line
+	// directives and random block ids
+	_, elsewhere := countIn("/home/ci/tidb", SampledGranularity, 3)
+	normalize := func(out string) string {
+		out = regexp.MustCompile(`//line .*`).ReplaceAllString(out, "")
+		out = regexp.MustCompile(`AddCount\(\d+, \d+\)`).ReplaceAllString(out, "AddCount()")
+		return strings.Join(strings.Fields(out), " ")
+	}
+	assert.Equal(t, normalize(sampledOut), normalize(elsewhere))
+	all, _ := count(SampledGranularity, 1)
+	assert.Equal(t, block, all)
+}
+
+func TestGranularityRules(t *testing.T) {
+	rules, err := ParseGranularityRules(BlockGranularity, "executor=func,executor/aggfuncs=sample")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, BlockGranularity, rules.For("."))
+	assert.Equal(t, BlockGranularity, rules.For("planner/core"))
+	assert.Equal(t, FuncGranularity, rules.For("executor"))
+	assert.Equal(t, FuncGranularity, rules.For("executor/join"))
+	assert.Equal(t, SampledGranularity, rules.For("executor/aggfuncs"))
+
+	_, err = ParseGranularityRules(BlockGranularity, "executor")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseGranularityRules(BlockGranularity, "executor=line")
+	assert.NotEqual(t, nil, err)
+}
+
+// constructs after go 1.13; every one should still type check after instrumented
+var modernCode = map[string]string{
+	"generic function": `package p
+func Sum[T ~int | ~float64](xs ...T) T {
+	var s T
+	for _, x := range xs {
+		if x > 0 && s >= 0 {
+			s += x
+		}
+	}
+	return s
+}`,
+	"generic method": `package p
+type List[T any] struct{ items []T }
+func (l *List[T]) Push(v T) {
+	if l == nil {
+		return
+	}
+	l.items = append(l.items, v)
+}`,
+	"multiple type parameters": `package p
+type Pair[K comparable, V any] struct {
+	k K
+	v V
+}
+func (p *Pair[K, V]) Key() K {
+	if p == nil {
+		var k K
+		return k
+	}
+	return p.k
+}`,
+	"method value on generic type": `package p
+type Box[T any] struct{ v T }
+func (b Box[T]) Get() T { return b.v }
+func F() int {
+	get := Box[int]{v: 1}.Get
+	if get() == 1 || get() > 2 {
+		return 1
+	}
+	return 0
+}`,
+	"range over int": `package p
+func F() (n int) {
+	for i := range 10 {
+		if i%2 == 0 {
+			n++
+		}
+	}
+	for range 3 {
+		n--
+	}
+	return
+}`,
+	"range over func": `package p
+import "iter"
+func Seq(n int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+func F() (n int) {
+	for i := range Seq(10) {
+		if i == 5 {
+			break
+		}
+		n += i
+	}
+	return
+}`,
+	"comparison of type parameter": `package p
+func F[T ~string](x T) bool {
+	switch x {
+	case "a":
+		return true
+	}
+	return x == "b"
+}`,
+}
+
+func TestModernSyntax(t *testing.T) {
+	options := map[string]func(v *Visitor){
+		"block":   func(v *Visitor) {},
+		"func":    func(v *Visitor) { v.Granularity = FuncGranularity },
+		"dynamic": func(v *Visitor) { v.Mode = DynamicEdge },
+		"cmp":     func(v *Visitor) { v.CmpLog = true; v.CallContext = true },
+	}
+	// the dep package is imported from source, so share the importer
+	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+
+	for name, code := range modernCode {
+		for optName, opt := range options {
+			fset := token.NewFileSet()
+			astFile, err := parser.ParseFile(fset, "", code, parser.ParseComments)
+			assert.Equal(t, nil, err, name)
+
+			visitor := NewVisitorPtr(fset)
+			opt(visitor)
+			ast.Walk(visitor, astFile)
+			assert.True(t, visitor.Changed, "%s in %s", name, optName)
+			visitor.AddImportDecl(astFile)
+
+			out := AstToBytes(astFile, fset).String()
+			assert.Regexp(t, `AddCount\(|AddBlock\(`, out, "%s in %s", name, optName)
+			fset = token.NewFileSet()
+			astFile, err = parser.ParseFile(fset, "", out, 0)
+			if !assert.Equal(t, nil, err, "%s in %s:\n%s", name, optName, out) {
+				continue
+			}
+			conf := types.Config{Importer: imp}
+			_, err = conf.Check("p", fset, []*ast.File{astFile}, nil)
+			assert.Equal(t, nil, err, "%s in %s:\n%s", name, optName, out)
+		}
+	}
+}
+
+var directiveCode = map[string]struct {
+	code   string
+	expect []string // directives with the lines they're followed by
+}{
+	"build constraints": {`//go:build linux && !race
+// +build linux,!race
+
+// Package p is only built on linux
+package p
+
+func F(x int) int {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}`, []string{"//go:build linux && !race\n// +build linux,!race\n\npackage p"}},
+	"embed in var block": {`package p
+
+import "embed"
+
+var (
+	// the templates
+	//go:embed templates/*.html
+	//go:embed static
+	assets embed.FS
+
+	//go:embed version.txt
+	version string
+)
+
+func F() bool {
+	if version == "" {
+		return false
+	}
+	return true
+}`, []string{"//go:embed templates/*.html\n\t//go:embed static\n\tassets\tembed.FS", "//go:embed version.txt\n\tversion\tstring"}},
+	"stacked linkname": {`package p
+
+import _ "unsafe"
+
+// nanotime is of runtime
+//go:linkname nanotime runtime.nanotime
+//go:noescape
+func nanotime() int64
+
+func F() int64 {
+	if n := nanotime(); n > 0 {
+		return n
+	}
+	return 0
+}`, []string{"//go:linkname nanotime runtime.nanotime\n//go:noescape\nfunc nanotime() int64"}},
+	"no imports": {`package p
+
+//go:noinline
+func F(x int) int {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}`, []string{"//go:noinline\nfunc F(x int) int"}},
+}
+
+func TestDirectives(t *testing.T) {
+	for name, c := range directiveCode {
+		fset := token.NewFileSet()
+		astFile, err := parser.ParseFile(fset, "", c.code, parser.ParseComments)
+		assert.Equal(t, nil, err, name)
+		KeepDirectives(astFile)
+
+		visitor := NewVisitorPtr(fset)
+		visitor.CmpLog = true
+		ast.Walk(visitor, astFile)
+		visitor.AddImportDecl(astFile)
+		// printed like the builder does
+		out := new(bytes.Buffer)
+		cfg := printer.Config{Tabwidth: 8}
+		assert.Equal(t, nil, cfg.Fprint(out, fset, astFile), name)
+
+		assert.Contains(t, out.String(), "AddCount(", name)
+		for _, expect := range c.expect {
+			assert.Contains(t, out.String(), expect, name)
+		}
+		// other comments are dropped
+		assert.NotContains(t, out.String(), "// Package", name)
+		assert.NotContains(t, out.String(), "// the templates", name)
+		assert.NotContains(t, out.String(), "// nanotime", name)
+		_, err = parser.ParseFile(token.NewFileSet(), "", out.Bytes(), 0)
+		assert.Equal(t, nil, err, "%s:\n%s", name, out)
+	}
+}
+
+const recoverCode = `package p
+func F() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	defer func() { recover() }()
+	recover := func() int { return 0 }
+	_ = recover(1)
+	return nil
+}`
+
+func TestRecover(t *testing.T) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", recoverCode, 0)
+	assert.Equal(t, nil, err)
+
+	visitor := NewVisitorPtr(fset)
+	visitor.Recover = true
+	ast.Walk(visitor, astFile)
+
+	out := AstToBytes(astFile, fset).String()
+	assert.Equal(t, 2, strings.Count(out, "__tidb_go_fuzz_dep.ReportRecover("))
+	assert.Regexp(t, `if r := __tidb_go_fuzz_dep.ReportRecover\(\d+, recover\(\)\); r != nil`, out)
+	assert.Contains(t, out, "recover(1)")
+}
+
+const exitCode = `package p
+import (
+	"log"
+	"os"
+	"testing"
+	plog "github.com/pingcap/log"
+	"github.com/pingcap/tidb/util/logutil"
+	"go.uber.org/zap"
+)
+type server struct {
+	logger *zap.Logger
+}
+func (s *server) getLogger() *zap.Logger {
+	return s.logger
+}
+func G(s *server, t *testing.T) {
+	l := logutil.BgLogger().With(zap.String("k", "v"))
+	l.Fatal("variable")
+	s.logger.Fatal("field")
+	s.getLogger().Fatalf("call")
+	t.Fatal()
+}
+func F(err error) {
+	if err != nil {
+		log.Fatalf("err %v", err)
+	}
+	switch {
+	case err == nil:
+		plog.Fatal("nil", zap.Error(err))
+	default:
+		logutil.BgLogger().Fatal("fatal")
+	}
+	t.Fatal()
+	log.Println("not fatal")
+	os.Exit(run())
+}`
+
+func TestHookExit(t *testing.T) {
+	for _, g := range []Granularity{BlockGranularity, FuncGranularity} {
+		fset := token.NewFileSet()
+		astFile, err := parser.ParseFile(fset, "", exitCode, 0)
+		assert.Equal(t, nil, err)
+
+		visitor := NewVisitorPtr(fset)
+		visitor.HookExit = true
+		visitor.Granularity = g
+		ast.Walk(visitor, astFile)
+
+		out := AstToBytes(astFile, fset).String()
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "log.Fatalf"\)\n\s*log.Fatalf\("err %v", err\)`, out)
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "plog.Fatal"\)\n\s*plog.Fatal\(`, out)
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "logutil.BgLogger\(\).Fatal"\)\n\s*logutil.BgLogger\(\).Fatal\(`, out)
+		// loggers in variables, fields and returned by functions
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "l.Fatal"\)\n\s*l.Fatal\(`, out)
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "s.logger.Fatal"\)\n\s*s.logger.Fatal\(`, out)
+		assert.Regexp(t, `__tidb_go_fuzz_dep.ReportFatal\(\d+, "s.getLogger\(\).Fatalf"\)\n\s*s.getLogger\(\).Fatalf\(`, out)
+		// not a logger
+		assert.NotContains(t, out, `"t.Fatal"`)
+		assert.Regexp(t, `os.Exit\(__tidb_go_fuzz_dep.ReportExit\(\d+, run\(\)\)\)`, out)
+		assert.Equal(t, 6, strings.Count(out, "ReportFatal("))
+	}
+}
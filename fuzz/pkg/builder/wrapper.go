@@ -9,10 +9,12 @@ import (
 	"go/token"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/Illyrix/tidb-go-fuzz/dep/types"
 )
@@ -21,6 +23,9 @@ import (
 const FUZZ_DEP_IMPORT_AS = "__tidb_go_fuzz_dep"
 const FUZZ_DEP_IMPORT_NAME = "github.com/Illyrix/tidb-go-fuzz/dep"
 
+// written into the root of target dir, see WriteBlockMap
+const BLOCK_MAP_FILE = "tidb-go-fuzz-blocks.txt"
+
 // then use `__tidb_go_fuzz_dep.GetTraceTable()` to fetch the singleton
 // TraceTable instance
 
@@ -56,6 +61,27 @@ func makeCountNode(src, dst types.BlockIdType) ast.Stmt {
 	}
 }
 
+// `__tidb_go_fuzz_dep.AddBlock(id)`, the runtime computes the edge from
+// the block executed previously by the same goroutine
+func makeBlockNode(id types.BlockIdType) ast.Stmt {
+	return makeDepCall("AddBlock", makeIntLit(id))
+}
+
+// `__tidb_go_fuzz_dep.EnterFunc(id); defer __tidb_go_fuzz_dep.ExitFunc()`
+func makeEnterFuncNodes(id types.BlockIdType) []ast.Stmt {
+	return []ast.Stmt{
+		makeDepCall("EnterFunc", makeIntLit(id)),
+		&ast.DeferStmt{Call: makeDepCallExpr("ExitFunc")},
+	}
+}
+
+func makeIntLit(id types.BlockIdType) ast.Expr {
+	return &ast.BasicLit{
+		Kind:  token.INT,
+		Value: strconv.FormatUint(uint64(id), 10),
+	}
+}
+
 // `go add .../tidb-go-fuzz/dep`
 func InstallDep(root string) {
 	shellCmd := exec.Command("go", "get", "-u", "github.com/Illyrix/tidb-go-fuzz/dep")
@@ -83,6 +109,114 @@ func CompileTidb(root string) {
 
 // inject calling `tidb_go_fuzz.Listen()` on startup
 func AddListenStart(root string) {
+	addFuncStartCall(root, "main", makeDepCall("Listen"))
+}
+
+// inject calling `tidb_go_fuzz.EnableMetrics(addr)` on startup; the metrics
+// are always exposed on tidb status port, and also on addr if it's not empty
+func AddMetricsStart(root, addr string) {
+	addFuncStartCall(root, "main", makeDepCall("EnableMetrics", makeStringLit(addr)))
+}
+
+// inject calling `tidb_go_fuzz.EnableCoverageDump(path, buildID, interval)`
+// on startup, and `tidb_go_fuzz.DumpCoverage()` before graceful exit
+func AddCoverageDump(root, path, buildID string, interval int) {
+	addFuncStartCall(root, "main", makeDepCall("EnableCoverageDump",
+		makeStringLit(path),
+		makeStringLit(buildID),
+		&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(interval)},
+	))
+	// tidb-server calls `exit()` after closing the server gracefully
+	addFuncStartCall(root, "exit", makeDepCall("DumpCoverage"))
+}
+
+// inject calling `tidb_go_fuzz.EnableTraceRoute(size)` on startup
+func AddTraceRoute(root string, size int) {
+	addFuncStartCall(root, "main", makeDepCall("EnableTraceRoute",
+		&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(size)}))
+}
+
+// write where every block id is injected, one block per line:
+// `id	file:startLine.startColumn,endLine.endColumn`
+// file is relative to root. Ids of EnterFunc span the function, followed by
+// `	func name`, where name is like FuncName
+func WriteBlockMap(path, root string, blocks []*types.Block) error {
+	out := new(bytes.Buffer)
+	for _, b := range blocks {
+		file, err := filepath.Rel(root, b.File)
+		if err != nil {
+			file = b.File
+		}
+		fmt.Fprintf(out, "%d\t%s:%d.%d,%d.%d", b.Id, file,
+			b.Start.Line, b.Start.Column, b.End.Line, b.End.Column)
+		if b.Func != "" {
+			fmt.Fprintf(out, "\tfunc %s", b.Func)
+		}
+		out.WriteByte('\n')
+	}
+	return ioutil.WriteFile(path, out.Bytes(), 0644)
+}
+
+// inject calling `tidb_go_fuzz.EnableCallContext(depth)` on startup
+func AddCallContext(root string, depth int) {
+	addFuncStartCall(root, "main", makeDepCall("EnableCallContext",
+		&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(depth)}))
+}
+
+// inject calling `tidb_go_fuzz.EnableCmpLog(size)` on startup
+func AddCmpLog(root string, size int) {
+	addFuncStartCall(root, "main", makeDepCall("EnableCmpLog",
+		&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(size)}))
+}
+
+// inject calling `tidb_go_fuzz.EnableRecoverLog(size)` on startup; at most
+// size panics recovered by tidb are kept for every statement
+func AddRecoverLog(root string, size int) {
+	addFuncStartCall(root, "main", makeDepCall("EnableRecoverLog",
+		&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(size)}))
+}
+
+// inject calling `tidb_go_fuzz.EnableCrashReport(path)` on startup; reports
+// of os.Exit and fatal logs hooked by the walker are appended to path
+func AddCrashReport(root, path string) {
+	addFuncStartCall(root, "main", makeDepCall("EnableCrashReport", makeStringLit(path)))
+}
+
+// build id is written into coverage dumps to tell which build they are from
+func NewBuildID() string {
+	return fmt.Sprintf("%s-%08x", time.Now().Format("20060102-150405"), rand.Uint32())
+}
+
+func makeStringLit(str string) ast.Expr {
+	return &ast.BasicLit{
+		Kind:  token.STRING,
+		Value: strconv.Quote(str),
+	}
+}
+
+func makeDepCall(name string, args ...ast.Expr) ast.Stmt {
+	return &ast.ExprStmt{
+		X: makeDepCallExpr(name, args...),
+	}
+}
+
+func makeDepCallExpr(name string, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X: &ast.Ident{
+				Name: FUZZ_DEP_IMPORT_AS,
+			},
+			Sel: &ast.Ident{
+				Name: name,
+			},
+		},
+		Args: args,
+	}
+}
+
+// insert the call at the beginning of function funcName in tidb-server/main.go;
+// main.go is left as is with a warning if funcName is not found
+func addFuncStartCall(root, funcName string, call ast.Stmt) {
 	// located at tidb-server/main.go
 	main := filepath.Join(root, "tidb-server", "main.go")
 	fset := token.NewFileSet()
@@ -95,29 +229,22 @@ func AddListenStart(root string) {
 	if err != nil {
 		panic(err)
 	}
+	found := false
 	for _, decl := range aFile.Decls {
 		funcDecl, ok := decl.(*ast.FuncDecl)
 		if !ok {
 			continue
 		}
-		if funcDecl.Name.Name != "main" {
+		if funcDecl.Name.Name != funcName || funcDecl.Recv != nil {
 			continue
 		}
 
-		callListen := &ast.ExprStmt{
-			X: &ast.CallExpr{
-				Fun: &ast.SelectorExpr{
-					X: &ast.Ident{
-						Name: FUZZ_DEP_IMPORT_AS,
-					},
-					Sel: &ast.Ident{
-						Name: "Listen",
-					},
-				},
-			},
-		}
-
-		funcDecl.Body.List = append([]ast.Stmt{callListen}, funcDecl.Body.List...)
+		funcDecl.Body.List = append([]ast.Stmt{call}, funcDecl.Body.List...)
+		found = true
+	}
+	if !found {
+		log.Printf("warning: function %s is not found in %s, the call is not injected\n", funcName, main)
+		return
 	}
 
 	out := new(bytes.Buffer)
@@ -135,3 +135,34 @@ func TestAddListenStart(t *testing.T) {
 	checkStr := lines[87] + lines[91]
 	assert.Equal(t, checkStr, "__tidb_go_fuzz_dep.Listen()")
 }
+
+func TestMakeDepCall(t *testing.T) {
+	stmt := makeDepCall("EnableMetrics", &ast.BasicLit{Kind: token.STRING, Value: `"127.0.0.1:16802"`})
+
+	out := AstToBytes(stmt, token.NewFileSet())
+
+	assert.Equal(t, out.String(), `__tidb_go_fuzz_dep.EnableMetrics("127.0.0.1:16802")`)
+}
+
+func TestMakeBlockNode(t *testing.T) {
+	stmt := makeBlockNode(0x31AF)
+
+	out := AstToBytes(stmt, token.NewFileSet())
+
+	assert.Equal(t, out.String(), "__tidb_go_fuzz_dep.AddBlock(12719)")
+}
+
+func TestAddFuncStartCallNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidb-go-fuzz-wrapper")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	main := filepath.Join(dir, "tidb-server", "main.go")
+	assert.Equal(t, nil, os.MkdirAll(filepath.Dir(main), os.ModePerm))
+	src := "package main\n\nfunc main() {}\n"
+	assert.Equal(t, nil, ioutil.WriteFile(main, []byte(src), 0644))
+
+	addFuncStartCall(dir, "exit", makeDepCall("DumpCoverage"))
+	content, err := ioutil.ReadFile(main)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, src, string(content))
+}
@@ -0,0 +1,132 @@
+package dict
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Token is a dictionary entry with how many times it's found in source
+type Token struct {
+	Value string
+	Count int
+}
+
+// Dictionary counts tokens, and keeps them in the dictionary format of
+// AFL: one `name="value"` per line, and the name is like `count_12`
+type Dictionary struct {
+	counts map[string]int
+}
+
+func New() *Dictionary {
+	return &Dictionary{counts: make(map[string]int)}
+}
+
+func (d *Dictionary) Add(value string) {
+	d.counts[value]++
+}
+
+func (d *Dictionary) Len() int {
+	return len(d.counts)
+}
+
+// Tokens found at least min times, the most frequent first
+func (d *Dictionary) Tokens(min int) []Token {
+	res := make([]Token, 0, len(d.counts))
+	for value, count := range d.counts {
+		if count >= min {
+			res = append(res, Token{Value: value, Count: count})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Value < res[j].Value
+	})
+	return res
+}
+
+func Write(w io.Writer, tokens []Token) error {
+	bw := bufio.NewWriter(w)
+	for _, t := range tokens {
+		fmt.Fprintf(bw, "count_%d=\"%s\"\n", t.Count, escape(t.Value))
+	}
+	return bw.Flush()
+}
+
+// Read parses an AFL dictionary; entries without counts in their names
+// count once, and comments, `@level` suffixes and empty values are ignored
+func Read(r io.Reader) ([]Token, error) {
+	var res []Token
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		quote := strings.IndexByte(text, '"')
+		if quote < 0 || len(text) < quote+2 || text[len(text)-1] != '"' {
+			return nil, fmt.Errorf("dictionary line %d: no quoted value", line)
+		}
+		value, err := unescape(text[quote+1 : len(text)-1])
+		if err != nil {
+			return nil, fmt.Errorf("dictionary line %d: %v", line, err)
+		}
+		if value == "" {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(text[:quote]), "=")
+		if i := strings.IndexByte(name, '@'); i >= 0 {
+			name = name[:i]
+		}
+		count := 1
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, "count_")); err == nil && n > 0 {
+			count = n
+		}
+		res = append(res, Token{Value: value, Count: count})
+	}
+	return res, scanner.Err()
+}
+
+// AFL only allows printable chars other than `"` and `\`, others are `\xNN`
+func escape(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '"' || c == '\\' || c < 0x20 || c > 0x7e {
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func unescape(value string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			sb.WriteByte(value[i])
+			continue
+		}
+		if i+1 < len(value) && (value[i+1] == '\\' || value[i+1] == '"') {
+			sb.WriteByte(value[i+1])
+			i++
+			continue
+		}
+		if i+4 > len(value) || value[i+1] != 'x' {
+			return "", fmt.Errorf("bad escape at %d", i)
+		}
+		c, err := strconv.ParseUint(value[i+2:i+4], 16, 8)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(byte(c))
+		i += 3
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,38 @@
+package dict
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDictionary(t *testing.T) {
+	d := New()
+	for _, value := range []string{"SELECT", "abs", "SELECT", "a\"b\\c\n"} {
+		d.Add(value)
+	}
+	assert.Equal(t, 3, d.Len())
+	assert.Equal(t, []Token{{"SELECT", 2}}, d.Tokens(2))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, Write(buf, d.Tokens(1)))
+	assert.Equal(t, "count_2=\"SELECT\"\ncount_1=\"a\\x22b\\x5cc\\x0a\"\ncount_1=\"abs\"\n", buf.String())
+
+	tokens, err := Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, d.Tokens(1), tokens)
+}
+
+func TestReadAFL(t *testing.T) {
+	src := "# comment\n\nkw1=\"select\"\nkw2@1=\"\\\"\\\\\"\nempty=\"\"\n\"from\"\n"
+	tokens, err := Read(strings.NewReader(src))
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{{"select", 1}, {"\"\\", 1}, {"from", 1}}, tokens)
+
+	_, err = Read(strings.NewReader("kw=select\n"))
+	assert.NotNil(t, err)
+	_, err = Read(strings.NewReader("kw=\"\\x4\"\n"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,103 @@
+package sqlfuzz
+
+import (
+	"math"
+	"math/rand"
+)
+
+// counts of arms are halved after this many uses, so yields of recent
+// mutations weigh more than the ones of the early run
+const banditWindow = 4096
+
+// Arm is a mutator chosen by BanditMutator
+type Arm struct {
+	Name    string
+	Mutator Mutator
+}
+
+// BanditMutator chooses a mutator for every mutation by UCB1: the one with
+// the highest yield of new coverage and crashes, plus a bonus for the ones
+// rarely used
+type BanditMutator struct {
+	*stageStats
+	arms []Arm
+}
+
+func NewBanditMutator(arms ...Arm) *BanditMutator {
+	var names []string
+	for _, arm := range arms {
+		names = append(names, arm.Name)
+	}
+	return &BanditMutator{stageStats: newStageStats(names), arms: arms}
+}
+
+func (m *BanditMutator) Mutate(r *rand.Rand, sql string) string {
+	i := m.choose(r)
+	res := m.arms[i].Mutator.Mutate(r, sql)
+	m.applied(res, i)
+	return res
+}
+
+func (m *BanditMutator) Learn(sql string) {
+	for _, arm := range m.arms {
+		if l, ok := arm.Mutator.(Learner); ok {
+			l.Learn(sql)
+		}
+	}
+}
+
+func (m *BanditMutator) Feedback(sql string, interesting bool) {
+	m.stageStats.Feedback(sql, interesting)
+	for _, arm := range m.arms {
+		if fb, ok := arm.Mutator.(Feedback); ok {
+			fb.Feedback(sql, interesting)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := uint64(0)
+	for _, s := range m.stats {
+		total += s.Uses
+	}
+	if total >= banditWindow {
+		for i := range m.stats {
+			m.stats[i].Uses /= 2
+			m.stats[i].Finds /= 2
+		}
+	}
+}
+
+// choose returns the arm of the highest upper confidence bound of yield;
+// unused arms are tried first, and ties are broken randomly. As yields are
+// far below 1, the bonus is scaled by the yield of all arms
+func (m *BanditMutator) choose(r *rand.Rand) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var uses, finds uint64
+	var unused []int
+	for i, s := range m.stats {
+		uses += s.Uses
+		finds += s.Finds
+		if s.Uses == 0 {
+			unused = append(unused, i)
+		}
+	}
+	if len(unused) > 0 {
+		return unused[r.Intn(len(unused))]
+	}
+
+	yield := float64(finds) / float64(uses)
+	var best []int
+	bestScore := 0.0
+	for i, s := range m.stats {
+		score := float64(s.Finds)/float64(s.Uses) + yield*math.Sqrt(2*math.Log(float64(uses))/float64(s.Uses))
+		switch {
+		case len(best) == 0 || score > bestScore:
+			best, bestScore = []int{i}, score
+		case score == bestScore:
+			best = append(best, i)
+		}
+	}
+	return best[r.Intn(len(best))]
+}
@@ -0,0 +1,62 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type constMutator string
+
+func (m constMutator) Mutate(r *rand.Rand, sql string) string {
+	return string(m)
+}
+
+func TestBanditMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	token := NewTokenMutator(&TextMutator{})
+	m := NewBanditMutator(Arm{"good", constMutator("good")}, Arm{"bad", constMutator("bad")}, Arm{"token", token})
+
+	// every arm is tried first
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		sql := m.Mutate(r, "select a")
+		seen[sql] = true
+		m.Feedback(sql, sql == "good")
+	}
+	assert.True(t, seen["good"])
+	assert.True(t, seen["bad"])
+	assert.Len(t, seen, 3)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		sql := m.Mutate(r, "select a")
+		counts[sql]++
+		m.Feedback(sql, sql == "good" && i%2 == 0)
+	}
+	assert.Greater(t, counts["good"], 800)
+	assert.Greater(t, counts["bad"], 0)
+
+	stats := m.Stats()
+	assert.Equal(t, "good", stats[0].Name)
+	assert.Greater(t, stats[0].Finds, uint64(0))
+	assert.Zero(t, stats[1].Finds)
+
+	m.Learn("select `my name` from t")
+	assert.Contains(t, token.names.names, "my name")
+}
+
+func TestBanditWindow(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewBanditMutator(Arm{"a", constMutator("a")}, Arm{"b", constMutator("b")})
+	for i := 0; i < 3*banditWindow; i++ {
+		sql := m.Mutate(r, "")
+		m.Feedback(sql, sql == "a")
+	}
+	total := uint64(0)
+	for _, s := range m.Stats() {
+		total += s.Uses
+	}
+	assert.Less(t, total, uint64(banditWindow))
+}
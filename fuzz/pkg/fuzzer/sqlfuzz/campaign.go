@@ -0,0 +1,312 @@
+package sqlfuzz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dtypes "github.com/Illyrix/tidb-go-fuzz/dep/types"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/types"
+)
+
+// layout of a campaign directory
+const (
+	QueueDir         = "queue"   // inputs hitting new coverage
+	CrashesDir       = "crashes" // inputs crashing tidb-server or causing recovered panics
+	HangsDir         = "hangs"   // inputs timed out with unique coverage
+	CoverageFile     = "coverage"
+	HangCoverageFile = "hang-coverage" // edges of timed out inputs, hangs are saved only if they hit new ones
+	StatsFile        = "stats"
+	ConfigFile       = "config.json"
+)
+
+// Campaign keeps the state of a fuzzing run in a directory, so the run
+// can be resumed after restarts
+type Campaign struct {
+	Dir string
+
+	nextId map[string]int // of every sub directory
+	mu     sync.Mutex
+}
+
+// QueueEntry is an input saved in queue
+type QueueEntry struct {
+	File string
+	SQL  string
+}
+
+// OpenCampaign creates the layout in dir; an existing campaign is only
+// opened with resume, to avoid mixing two runs
+func OpenCampaign(dir string, resume bool) (*Campaign, error) {
+	c := &Campaign{Dir: dir, nextId: make(map[string]int)}
+	if !resume {
+		if files, _ := ioutil.ReadDir(filepath.Join(dir, QueueDir)); len(files) > 0 {
+			return nil, fmt.Errorf("campaign %s exists, resume it or use another directory", dir)
+		}
+	}
+	for _, sub := range []string{QueueDir, CrashesDir, HangsDir} {
+		path := filepath.Join(dir, sub)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if id, ok := parseId(file.Name()); ok && id >= c.nextId[sub] {
+				c.nextId[sub] = id + 1
+			}
+		}
+	}
+	return c, nil
+}
+
+// files are named like `id-000001.sql`, with optional `id-000001.txt` reports
+func parseId(name string) (int, bool) {
+	if !strings.HasPrefix(name, "id-") || !strings.HasSuffix(name, ".sql") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "id-"), ".sql"))
+	return id, err == nil
+}
+
+// add writes sql as the next input of sub, and report next to it if not empty
+func (c *Campaign) add(sub, sql, report string) (string, error) {
+	c.mu.Lock()
+	id := c.nextId[sub]
+	c.nextId[sub]++
+	c.mu.Unlock()
+
+	path := filepath.Join(c.Dir, sub, fmt.Sprintf("id-%06d.sql", id))
+	if err := ioutil.WriteFile(path, []byte(sql), 0644); err != nil {
+		return "", err
+	}
+	if report != "" {
+		if err := ioutil.WriteFile(strings.TrimSuffix(path, ".sql")+".txt", []byte(report), 0644); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+func (c *Campaign) AddQueue(sql string) (string, error) {
+	return c.add(QueueDir, sql, "")
+}
+
+func (c *Campaign) AddCrash(sql, report string) (string, error) {
+	return c.add(CrashesDir, sql, report)
+}
+
+func (c *Campaign) AddHang(sql string) (string, error) {
+	return c.add(HangsDir, sql, "")
+}
+
+// LoadCrashReports returns reports next to inputs in crashes
+func (c *Campaign) LoadCrashReports() ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(c.Dir, CrashesDir))
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".txt") {
+			continue
+		}
+		report, err := ioutil.ReadFile(filepath.Join(c.Dir, CrashesDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, string(report))
+	}
+	return res, nil
+}
+
+// LoadQueue returns inputs in queue in the order they're found
+func (c *Campaign) LoadQueue() ([]QueueEntry, error) {
+	files, err := ioutil.ReadDir(filepath.Join(c.Dir, QueueDir))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, file := range files {
+		if id, ok := parseId(file.Name()); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	res := make([]QueueEntry, 0, len(ids))
+	for _, id := range ids {
+		path := filepath.Join(c.Dir, QueueDir, fmt.Sprintf("id-%06d.sql", id))
+		sql, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, QueueEntry{File: path, SQL: string(sql)})
+	}
+	return res, nil
+}
+
+// SaveCoverage writes cov as a coverage dump; it's written to a temporary
+// file first, so a killed fuzzer doesn't leave a broken map
+func (c *Campaign) SaveCoverage(cov *dtypes.Coverage, buildID string) error {
+	return c.saveDump(CoverageFile, cov, buildID)
+}
+
+// LoadCoverage returns nil if the map has never been saved
+func (c *Campaign) LoadCoverage() (*dtypes.CoverageDump, error) {
+	return c.loadDump(CoverageFile)
+}
+
+func (c *Campaign) SaveHangCoverage(cov *dtypes.Coverage, buildID string) error {
+	return c.saveDump(HangCoverageFile, cov, buildID)
+}
+
+func (c *Campaign) LoadHangCoverage() (*dtypes.CoverageDump, error) {
+	return c.loadDump(HangCoverageFile)
+}
+
+func (c *Campaign) saveDump(name string, cov *dtypes.Coverage, buildID string) error {
+	path := filepath.Join(c.Dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := cov.WriteDump(f, buildID); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (c *Campaign) loadDump(name string) (*dtypes.CoverageDump, error) {
+	f, err := os.Open(filepath.Join(c.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dtypes.ReadCoverageDump(bufio.NewReader(f))
+}
+
+func (c *Campaign) SaveConfig(config *types.FuzzerConfig) error {
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.Dir, ConfigFile), content, 0644)
+}
+
+// stats are saved as `key: value` lines, like fuzzer_stats of AFL
+func (c *Campaign) SaveStats(s Stats, corpus, edges int) error {
+	lines := []string{
+		fmt.Sprintf("last_update: %d", time.Now().Unix()),
+		fmt.Sprintf("run_time: %d", int64(s.RunTime.Seconds())),
+		fmt.Sprintf("execs: %d", s.Execs),
+		fmt.Sprintf("errors: %d", s.Errors),
+		fmt.Sprintf("timeouts: %d", s.Timeouts),
+		fmt.Sprintf("crashes: %d", s.Crashes),
+		fmt.Sprintf("panics: %d", s.Panics),
+		fmt.Sprintf("corpus: %d", corpus),
+		fmt.Sprintf("edges: %d", edges),
+	}
+	lines = append(lines, operatorLines("havoc", s.Havoc)...)
+	lines = append(lines, operatorLines("splice", s.Splice)...)
+	lines = append(lines, operatorLines("bandit", s.Bandit)...)
+	path := filepath.Join(c.Dir, StatsFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadStats returns the counters saved by SaveStats, or zeros if never saved
+func (c *Campaign) LoadStats() (Stats, error) {
+	var s Stats
+	content, err := ioutil.ReadFile(filepath.Join(c.Dir, StatsFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	counters := map[string]*uint64{
+		"execs":    &s.Execs,
+		"errors":   &s.Errors,
+		"timeouts": &s.Timeouts,
+		"crashes":  &s.Crashes,
+		"panics":   &s.Panics,
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			return s, fmt.Errorf("bad stats line %q", line)
+		}
+		if counter, ok := counters[kv[0]]; ok {
+			*counter = val
+		} else if kv[0] == "run_time" {
+			s.RunTime = time.Duration(val) * time.Second
+		} else if strings.HasPrefix(kv[0], "havoc_") {
+			loadOperatorStats(&s.Havoc, strings.TrimPrefix(kv[0], "havoc_"), val)
+		} else if strings.HasPrefix(kv[0], "splice_") {
+			loadOperatorStats(&s.Splice, strings.TrimPrefix(kv[0], "splice_"), val)
+		} else if strings.HasPrefix(kv[0], "bandit_") {
+			loadOperatorStats(&s.Bandit, strings.TrimPrefix(kv[0], "bandit_"), val)
+		}
+	}
+	return s, nil
+}
+
+// operatorLines are like `havoc_flip_bit_uses: 10`
+func operatorLines(stage string, stats []OperatorStats) []string {
+	var lines []string
+	for _, op := range stats {
+		lines = append(lines,
+			fmt.Sprintf("%s_%s_uses: %d", stage, op.Name, op.Uses),
+			fmt.Sprintf("%s_%s_finds: %d", stage, op.Name, op.Finds))
+	}
+	return lines
+}
+
+// loadOperatorStats sets the counter of key like `flip_bit_uses`
+func loadOperatorStats(stats *[]OperatorStats, key string, val uint64) {
+	var name string
+	finds := strings.HasSuffix(key, "_finds")
+	if finds {
+		name = strings.TrimSuffix(key, "_finds")
+	} else if strings.HasSuffix(key, "_uses") {
+		name = strings.TrimSuffix(key, "_uses")
+	} else {
+		return
+	}
+	i := 0
+	for i < len(*stats) && (*stats)[i].Name != name {
+		i++
+	}
+	if i == len(*stats) {
+		*stats = append(*stats, OperatorStats{Name: name})
+	}
+	if finds {
+		(*stats)[i].Finds = val
+	} else {
+		(*stats)[i].Uses = val
+	}
+}
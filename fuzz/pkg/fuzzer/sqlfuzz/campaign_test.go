@@ -0,0 +1,97 @@
+package sqlfuzz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dtypes "github.com/Illyrix/tidb-go-fuzz/dep/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "campaign")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := OpenCampaign(dir, false)
+	assert.Nil(t, err)
+	for _, sql := range []string{"select 1", "select 2"} {
+		_, err := c.AddQueue(sql)
+		assert.Nil(t, err)
+	}
+	file, err := c.AddCrash("select 3", "tidb-server is gone\n")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, CrashesDir, "id-000000.sql"), file)
+	report, err := ioutil.ReadFile(filepath.Join(dir, CrashesDir, "id-000000.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "tidb-server is gone\n", string(report))
+
+	cov := dtypes.NewCoverage()
+	tb := dtypes.NewTraceBits()
+	tb.AddCount(1, 2)
+	cov.Merge(tb)
+	assert.Nil(t, c.SaveCoverage(cov, "build-1"))
+	assert.Nil(t, c.SaveHangCoverage(dtypes.NewCoverage(), "build-1"))
+	havoc := []OperatorStats{{"flip_bit", 5, 1}, {"dict_insert", 3, 0}}
+	splice := []OperatorStats{{"clause", 4, 2}}
+	bandit := []OperatorStats{{"token", 8, 3}, {"havoc", 2, 0}}
+	assert.Nil(t, c.SaveStats(Stats{Execs: 10, Crashes: 1, RunTime: time.Minute, Havoc: havoc, Splice: splice, Bandit: bandit}, 2, 1))
+
+	// an existing campaign is not overwritten
+	_, err = OpenCampaign(dir, false)
+	assert.NotNil(t, err)
+
+	c, err = OpenCampaign(dir, true)
+	assert.Nil(t, err)
+	queue, err := c.LoadQueue()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(queue))
+	assert.Equal(t, "select 1", queue[0].SQL)
+	assert.Equal(t, "select 2", queue[1].SQL)
+
+	// ids continue after resuming
+	file, err = c.AddQueue("select 4")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, QueueDir, "id-000002.sql"), file)
+
+	dump, err := c.LoadCoverage()
+	assert.Nil(t, err)
+	assert.Equal(t, "build-1", dump.BuildID)
+	restored := dtypes.NewCoverage()
+	assert.Nil(t, restored.Restore(dump.Bits))
+	assert.Equal(t, 1, restored.EverHit())
+	dump, err = c.LoadHangCoverage()
+	assert.Nil(t, err)
+	assert.Equal(t, "build-1", dump.BuildID)
+
+	reports, err := c.LoadCrashReports()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tidb-server is gone\n"}, reports)
+
+	stats, err := c.LoadStats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), stats.Execs)
+	assert.Equal(t, uint64(1), stats.Crashes)
+	assert.Equal(t, time.Minute, stats.RunTime)
+	assert.Equal(t, havoc, stats.Havoc)
+	assert.Equal(t, splice, stats.Splice)
+	assert.Equal(t, bandit, stats.Bandit)
+}
+
+func TestEmptyCampaign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "campaign")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := OpenCampaign(dir, true)
+	assert.Nil(t, err)
+	dump, err := c.LoadCoverage()
+	assert.Nil(t, err)
+	assert.Nil(t, dump)
+	stats, err := c.LoadStats()
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{}, stats)
+}
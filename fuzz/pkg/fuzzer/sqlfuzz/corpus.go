@@ -0,0 +1,61 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Input is a test case kept in corpus because it hits new coverage
+type Input struct {
+	SQL        string
+	NewEdges   int // edges hit for the first time by it
+	NewBuckets int // edges hit with new hit-count buckets by it
+	ExecTime   time.Duration
+	Found      time.Time
+	File       string // in queue of the campaign; empty if not saved
+
+	Edges   []int  // indexes of edges hit by it
+	Path    uint64 // hash of Edges
+	Depth   int    // 1 for seeds, or 1 + depth of the input it's mutated from
+	Fuzzed  int    // times picked by the scheduler
+	Favored bool   // in the minimal set of inputs covering all edges
+}
+
+type Corpus struct {
+	inputs []*Input
+	mu     sync.RWMutex
+}
+
+func NewCorpus() *Corpus {
+	return &Corpus{}
+}
+
+func (c *Corpus) Add(input *Input) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inputs = append(c.inputs, input)
+}
+
+func (c *Corpus) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.inputs)
+}
+
+// Pick returns a random input, or nil if corpus is empty
+func (c *Corpus) Pick(r *rand.Rand) *Input {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.inputs) == 0 {
+		return nil
+	}
+	return c.inputs[r.Intn(len(c.inputs))]
+}
+
+// Inputs returns a copy of all inputs in the order they're found
+func (c *Corpus) Inputs() []*Input {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*Input(nil), c.inputs...)
+}
@@ -0,0 +1,202 @@
+package sqlfuzz
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// derivations deeper than it only use the shallowest productions
+const maxGrammarDepth = 24
+
+// derivations with more terminals than it only use the shallowest productions
+const maxGrammarTerminals = 512
+
+// a production always leading to new coverage weighs about 1+maxWeightBonus
+const maxWeightBonus = 10.0
+
+// the nonterminal of a single statement in parser.y of tidb
+const defaultGrammarStart = "Statement"
+
+var defaultNames = []string{"t", "t1", "t2", "a", "b", "c", "id"}
+
+// values of lexical classes of terminals
+var classValues = map[string][]string{
+	"int":      {"0", "1", "2", "10", "127", "255", "65535", "2147483647", "9223372036854775807", "18446744073709551615"},
+	"decimal":  {"0.0", "1.5", "3.14", "0.000001", "99999999999999999999.99999"},
+	"float":    {"1e0", "1.5e-3", "2E10", "1e308"},
+	"hex":      {"0x1F", "x'0aff'", "X''"},
+	"bit":      {"0b101", "b'1'"},
+	"variable": {"@a", "@v1"},
+	"sysvar":   {"@@sql_mode", "@@autocommit", "@@session.time_zone", "@@global.tidb_mem_quota_query"},
+	"charset":  {"_utf8mb4", "_binary", "_latin1"},
+}
+
+// GrammarMutator generates statements from a grammar like parser.y of
+// tidb instead of mutating inputs; productions deriving statements which
+// hit new coverage weigh more
+type GrammarMutator struct {
+	grammar *Grammar
+	start   string
+	names   *schemaNames
+
+	last     string // the last generated statement, waiting for feedback
+	lastUsed []*Production
+}
+
+// NewGrammarMutator derives statements from start, or `Statement` of tidb
+// if it's empty and the grammar has it, or the start symbol of the grammar
+func NewGrammarMutator(g *Grammar, start string) (*GrammarMutator, error) {
+	if start == "" {
+		start = g.Start
+		if _, ok := g.Rules[defaultGrammarStart]; ok {
+			start = defaultGrammarStart
+		}
+	}
+	prods, ok := g.Rules[start]
+	if !ok {
+		return nil, fmt.Errorf("grammar has no rule %s", start)
+	}
+	productive := false
+	for _, p := range prods {
+		productive = productive || p.minDepth != unproductive
+	}
+	if !productive {
+		return nil, fmt.Errorf("rule %s derives no statements", start)
+	}
+	return &GrammarMutator{grammar: g, start: start, names: newSchemaNames()}, nil
+}
+
+func (m *GrammarMutator) Mutate(r *rand.Rand, sql string) string {
+	// rules like `EmptyStmt` derive nothing
+	for try := 0; try < maxTokenTries && (m.last == "" || try == 0); try++ {
+		m.last, m.lastUsed = m.Generate(r)
+	}
+	return m.last
+}
+
+func (m *GrammarMutator) Learn(sql string) {
+	m.names.learn(sql)
+}
+
+// Feedback adapts weights of productions used by the last statement
+func (m *GrammarMutator) Feedback(sql string, interesting bool) {
+	if sql != m.last || m.lastUsed == nil {
+		return
+	}
+	for _, p := range m.lastUsed {
+		p.uses++
+		if interesting {
+			p.finds++
+		}
+		p.Weight = 1 + maxWeightBonus*float64(p.finds)/float64(p.uses+1)
+	}
+	m.lastUsed = nil
+}
+
+// Generate derives a statement, with the productions used
+func (m *GrammarMutator) Generate(r *rand.Rand) (string, []*Production) {
+	d := &derivation{m: m, r: r, used: make(map[*Production]bool)}
+	d.derive(m.start, 0)
+	used := make([]*Production, 0, len(d.used))
+	for p := range d.used {
+		used = append(used, p)
+	}
+	return joinTerminals(d.terminals), used
+}
+
+type derivation struct {
+	m         *GrammarMutator
+	r         *rand.Rand
+	terminals []string
+	used      map[*Production]bool
+}
+
+func (d *derivation) derive(name string, depth int) {
+	p := d.choose(d.m.grammar.Rules[name], depth)
+	d.used[p] = true
+	for _, s := range p.Symbols {
+		if s.Terminal {
+			d.terminals = append(d.terminals, d.m.terminal(d.r, s))
+		} else {
+			d.derive(s.Name, depth+1)
+		}
+	}
+}
+
+// choose picks a production by weight among the ones fitting in the depth
+// limit, or the shallowest one; the shallowest always derives a shallower
+// rule, so derivations end
+func (d *derivation) choose(prods []*Production, depth int) *Production {
+	var shallowest *Production
+	var candidates []*Production
+	total := 0.0
+	for _, p := range prods {
+		if p.minDepth == unproductive {
+			continue
+		}
+		if shallowest == nil || p.minDepth < shallowest.minDepth {
+			shallowest = p
+		}
+		if depth+p.minDepth <= maxGrammarDepth && len(d.terminals) < maxGrammarTerminals {
+			candidates = append(candidates, p)
+			total += p.Weight
+		}
+	}
+	if len(candidates) == 0 {
+		return shallowest
+	}
+	w := d.r.Float64() * total
+	for _, p := range candidates {
+		if w -= p.Weight; w < 0 {
+			return p
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (m *GrammarMutator) terminal(r *rand.Rand, s Symbol) string {
+	switch s.Class {
+	case "":
+		return s.Text
+	case "identifier":
+		name, ok := m.names.random(r)
+		if !ok || r.Intn(4) == 0 {
+			name = defaultNames[r.Intn(len(defaultNames))]
+		}
+		if !isBareIdent(name) {
+			return quoteIdent(name)
+		}
+		return name
+	case "string":
+		if r.Intn(4) == 0 {
+			return quoteString(interestingDates[r.Intn(len(interestingDates))], '\'')
+		}
+		return quoteString(interestingStrings[r.Intn(len(interestingStrings))], '\'')
+	}
+	values := classValues[s.Class]
+	return values[r.Intn(len(values))]
+}
+
+// joinTerminals joins terminals by spaces, except around `(`, `)`, `,`,
+// `;` and `.`, so function calls are like `count(*)`
+func joinTerminals(terminals []string) string {
+	var sb strings.Builder
+	for i, t := range terminals {
+		if i > 0 && spaceBetween(terminals[i-1], t) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t)
+	}
+	return sb.String()
+}
+
+func spaceBetween(prev, next string) bool {
+	switch {
+	case next == "(" || next == ")" || next == "," || next == "." || next == ";":
+		return false
+	case prev == "(" || prev == ".":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,377 @@
+package sqlfuzz
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// where parser.y is in a tidb checkout, or in pingcap/parser
+var grammarPaths = []string{"pkg/parser/parser.y", "parser/parser.y", "parser.y"}
+
+// FindGrammar returns path if it's a file, or the path of parser.y in the
+// tidb checkout path
+func FindGrammar(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	for _, p := range grammarPaths {
+		if info, err := os.Stat(filepath.Join(path, p)); err == nil && !info.IsDir() {
+			return filepath.Join(path, p), nil
+		}
+	}
+	return "", fmt.Errorf("no parser.y in %s", path)
+}
+
+// Symbol of a production: a nonterminal, or a terminal with its text; a
+// terminal of a lexical class like `identifier` has Class but no Text
+type Symbol struct {
+	Name     string
+	Terminal bool
+	Text     string
+	Class    string
+}
+
+// Production is an alternative of a rule, Weight is adapted by the
+// coverage found by statements derived by it
+type Production struct {
+	Lhs     string
+	Symbols []Symbol
+	Weight  float64
+
+	uses     int
+	finds    int
+	minDepth int // of the shallowest derivation into terminals; unproductive if it's infinite
+}
+
+type Grammar struct {
+	Start string
+	Rules map[string][]*Production
+}
+
+// lexical classes of tokens without fixed text
+var lexicalClasses = map[string]string{
+	"identifier":         "identifier",
+	"stringLit":          "string",
+	"intLit":             "int",
+	"decLit":             "decimal",
+	"floatLit":           "float",
+	"hexLit":             "hex",
+	"bitLit":             "bit",
+	"singleAtIdentifier": "variable",
+	"doubleAtIdentifier": "sysvar",
+	"underscoreCS":       "charset",
+}
+
+// tokens never generated, like `?` which needs arguments
+var unusableTokens = map[string]bool{
+	"paramMarker": true,
+	"invalid":     true,
+	"hintComment": true,
+}
+
+const unproductive = math.MaxInt32
+
+// LoadGrammar reads a goyacc grammar like parser.y of tidb
+func LoadGrammar(path string) (*Grammar, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGrammar(string(src))
+}
+
+// ParseGrammar reads token aliases and the start symbol in declarations,
+// and productions in rules; actions and the code section are ignored
+func ParseGrammar(src string) (*Grammar, error) {
+	sections := splitSections(src)
+	if len(sections) < 2 {
+		return nil, errors.New("grammar: no rules section")
+	}
+
+	// token names with their texts like `selectKwd "SELECT"`
+	aliases := make(map[string]string)
+	g := &Grammar{Rules: make(map[string][]*Production)}
+	directive := ""
+	decls := lexYacc(sections[0])
+	for i, item := range decls {
+		switch {
+		case item.kind == yaccDirective:
+			directive = item.text
+			if directive == "%start" && i+1 < len(decls) {
+				g.Start = decls[i+1].text
+			}
+		case directive == "%token" && item.kind == yaccString && i > 0 && decls[i-1].kind == yaccIdent:
+			if alias, err := strconv.Unquote(item.text); err == nil {
+				aliases[decls[i-1].text] = alias
+			}
+		}
+	}
+
+	// rules like `A: B "C" | D;`, a new rule starts at `name:`
+	var order []string
+	var prod *Production
+	items := lexYacc(sections[1])
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		switch {
+		case item.kind == yaccIdent && i+1 < len(items) && items[i+1].kind == yaccColon:
+			if _, ok := g.Rules[item.text]; !ok {
+				order = append(order, item.text)
+			}
+			prod = &Production{Lhs: item.text, Weight: 1}
+			g.Rules[item.text] = append(g.Rules[item.text], prod)
+			i++
+		case prod == nil:
+			return nil, fmt.Errorf("grammar: %q out of rules", item.text)
+		case item.kind == yaccPipe:
+			prod = &Production{Lhs: prod.Lhs, Weight: 1}
+			g.Rules[prod.Lhs] = append(g.Rules[prod.Lhs], prod)
+		case item.kind == yaccSemi:
+		case item.kind == yaccDirective:
+			// `%prec name` only changes precedence
+			if item.text == "%prec" {
+				i++
+			}
+		default:
+			prod.Symbols = append(prod.Symbols, Symbol{Name: item.text, Text: item.text})
+		}
+	}
+	if len(order) == 0 {
+		return nil, errors.New("grammar: no rules")
+	}
+	if g.Start == "" {
+		g.Start = order[0]
+	}
+
+	for _, prods := range g.Rules {
+		for _, p := range prods {
+			for i := range p.Symbols {
+				g.resolve(&p.Symbols[i], aliases)
+			}
+		}
+	}
+	g.computeMinDepth()
+	return g, nil
+}
+
+// resolve decides whether s is a terminal and what its text is
+func (g *Grammar) resolve(s *Symbol, aliases map[string]string) {
+	if _, ok := g.Rules[s.Name]; ok {
+		s.Text = ""
+		return
+	}
+	s.Terminal = true
+	if unusableTokens[s.Name] {
+		s.Text = ""
+		return
+	}
+	if class, ok := lexicalClasses[s.Name]; ok {
+		s.Text, s.Class = "", class
+		return
+	}
+	// literals in rules like "SELECT" and '(' are texts of themselves
+	if unquoted, err := strconv.Unquote(s.Name); err == nil {
+		s.Text = unquoted
+		return
+	}
+	// aliases like "integer literal" describe lexical classes unknown here
+	if alias, ok := aliases[s.Name]; ok && alias != s.Name && strings.ToUpper(alias) == alias {
+		s.Text = alias
+		return
+	}
+	s.Text = ""
+}
+
+// usable terminals have texts or known lexical classes
+func (s Symbol) usable() bool {
+	return !s.Terminal || s.Text != "" || s.Class != ""
+}
+
+// computeMinDepth finds the shallowest derivation of every production by
+// iterating until no depth decreases
+func (g *Grammar) computeMinDepth() {
+	ruleDepth := make(map[string]int)
+	for name, prods := range g.Rules {
+		ruleDepth[name] = unproductive
+		for _, p := range prods {
+			p.minDepth = unproductive
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, prods := range g.Rules {
+			for _, p := range prods {
+				depth := 1
+				for _, s := range p.Symbols {
+					switch {
+					case !s.usable():
+						depth = unproductive
+					case !s.Terminal && ruleDepth[s.Name] != unproductive && ruleDepth[s.Name]+1 > depth:
+						depth = ruleDepth[s.Name] + 1
+					case !s.Terminal && ruleDepth[s.Name] == unproductive:
+						depth = unproductive
+					}
+					if depth == unproductive {
+						break
+					}
+				}
+				if depth < p.minDepth {
+					p.minDepth = depth
+					changed = true
+				}
+				if depth < ruleDepth[name] {
+					ruleDepth[name] = depth
+				}
+			}
+		}
+	}
+}
+
+type yaccKind int
+
+const (
+	yaccIdent  yaccKind = iota
+	yaccString          // "..." or '.'
+	yaccColon
+	yaccPipe
+	yaccSemi
+	yaccDirective // like %token
+)
+
+type yaccItem struct {
+	kind yaccKind
+	text string
+}
+
+// splitSections splits src by `%%` lines
+func splitSections(src string) []string {
+	var res []string
+	start, offset := 0, 0
+	for _, line := range strings.SplitAfter(src, "\n") {
+		if strings.TrimSpace(line) == "%%" {
+			res = append(res, src[start:offset])
+			start = offset + len(line)
+		}
+		offset += len(line)
+	}
+	return append(res, src[start:])
+}
+
+// lexYacc returns symbols and punctuations of a section, skipping
+// comments, `<type>` tags, `%{ %}` code and `{}` actions
+func lexYacc(src string) []yaccItem {
+	var res []yaccItem
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			i = lineEnd(src, i)
+		case strings.HasPrefix(src[i:], "/*"):
+			i = commentEnd(src, i)
+		case strings.HasPrefix(src[i:], "%{"):
+			if end := strings.Index(src[i:], "%}"); end >= 0 {
+				i += end + 2
+			} else {
+				i = len(src)
+			}
+		case c == '{':
+			i = actionEnd(src, i)
+		case c == '<':
+			if end := strings.IndexByte(src[i:], '>'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(src)
+			}
+		case c == '"' || c == '\'':
+			end := goQuoteEnd(src, i)
+			res = append(res, yaccItem{yaccString, src[i:end]})
+			i = end
+		case c == ':' || c == '|' || c == ';':
+			res = append(res, yaccItem{map[byte]yaccKind{':': yaccColon, '|': yaccPipe, ';': yaccSemi}[c], string(c)})
+			i++
+		case c == '%':
+			end := i + 1
+			for end < len(src) && isIdentChar(src[end]) {
+				end++
+			}
+			res = append(res, yaccItem{yaccDirective, src[i:end]})
+			i = end
+		case isIdentChar(c) || c == '.':
+			end := i + 1
+			for end < len(src) && (isIdentChar(src[end]) || src[end] == '.') {
+				end++
+			}
+			res = append(res, yaccItem{yaccIdent, src[i:end]})
+			i = end
+		default:
+			i++
+		}
+	}
+	return res
+}
+
+func commentEnd(src string, i int) int {
+	if end := strings.Index(src[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(src)
+}
+
+// goQuoteEnd returns the end of a go string or char literal starting at i
+func goQuoteEnd(src string, i int) int {
+	quote := src[i]
+	for end := i + 1; end < len(src); end++ {
+		switch src[end] {
+		case '\\':
+			if quote != '`' {
+				end++
+			}
+		case quote:
+			return end + 1
+		case '\n':
+			if quote != '`' {
+				return end
+			}
+		}
+	}
+	return len(src)
+}
+
+// actionEnd returns the end of the go code in braces starting at i
+func actionEnd(src string, i int) int {
+	depth := 0
+	for i < len(src) {
+		switch c := src[i]; {
+		case c == '{':
+			depth++
+			i++
+		case c == '}':
+			depth--
+			i++
+			if depth == 0 {
+				return i
+			}
+		case c == '"' || c == '\'' || c == '`':
+			i = goQuoteEnd(src, i)
+		case strings.HasPrefix(src[i:], "//"):
+			i = lineEnd(src, i)
+		case strings.HasPrefix(src[i:], "/*"):
+			i = commentEnd(src, i)
+		default:
+			i++
+		}
+	}
+	return len(src)
+}
@@ -0,0 +1,128 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGrammar = `%{
+package parser
+%}
+
+%token	<ident>
+	selectKwd "SELECT"
+	from      "FROM"
+	where     "WHERE"
+	paramMarker "?"
+	identifier "identifier"
+	intLit     "integer literal"
+
+%start	Start
+
+%%
+
+Start:
+	Statement
+	{
+		parser.result = "}" // a brace in a string
+	}
+
+Statement:
+	/* empty */
+|	SelectStmt ';'
+
+SelectStmt:
+	"SELECT" FieldList from identifier WhereClauseOptional
+
+FieldList:
+	Expr
+|	FieldList ',' Expr %prec lowest
+
+Expr:
+	identifier
+|	intLit
+|	'(' Expr '+' Expr ')'
+|	paramMarker
+
+WhereClauseOptional:
+	{}
+|	where Expr
+
+Loop:
+	Loop selectKwd
+
+%%
+`
+
+func TestParseGrammar(t *testing.T) {
+	g, err := ParseGrammar(testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, "Start", g.Start)
+	assert.Len(t, g.Rules, 7)
+	assert.Len(t, g.Rules["Statement"], 2)
+	assert.Len(t, g.Rules["Expr"], 4)
+
+	sel := g.Rules["SelectStmt"][0]
+	assert.Equal(t, []Symbol{
+		{Name: `"SELECT"`, Terminal: true, Text: "SELECT"},
+		{Name: "FieldList"},
+		{Name: "from", Terminal: true, Text: "FROM"},
+		{Name: "identifier", Terminal: true, Class: "identifier"},
+		{Name: "WhereClauseOptional"},
+	}, sel.Symbols)
+	// `%prec lowest` is not a symbol
+	assert.Len(t, g.Rules["FieldList"][1].Symbols, 3)
+
+	assert.Equal(t, 1, g.Rules["Statement"][0].minDepth)
+	assert.Equal(t, 1, g.Rules["Expr"][0].minDepth)
+	assert.Equal(t, 2, g.Rules["FieldList"][0].minDepth)
+	assert.Equal(t, 3, sel.minDepth)
+	assert.Equal(t, unproductive, g.Rules["Expr"][3].minDepth)
+	assert.Equal(t, unproductive, g.Rules["Loop"][0].minDepth)
+
+	_, err = ParseGrammar("%token a\n")
+	assert.Error(t, err)
+}
+
+func TestGrammarMutator(t *testing.T) {
+	g, err := ParseGrammar(testGrammar)
+	require.NoError(t, err)
+	_, err = NewGrammarMutator(g, "Loop")
+	assert.Error(t, err)
+	_, err = NewGrammarMutator(g, "Unknown")
+	assert.Error(t, err)
+
+	m, err := NewGrammarMutator(g, "")
+	require.NoError(t, err)
+	assert.Equal(t, "Statement", m.start)
+	m.Learn("select name from users")
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		// the empty statement is rarely left after retries
+		sql := m.Mutate(r, "")
+		if sql == "" {
+			continue
+		}
+		assert.True(t, strings.HasPrefix(sql, "SELECT"), sql)
+		assert.True(t, strings.HasSuffix(sql, ";"), sql)
+		assert.NotContains(t, sql, "?")
+	}
+
+	// productions of interesting statements weigh more
+	where := g.Rules["WhereClauseOptional"][1]
+	for i := 0; i < 100; i++ {
+		sql := m.Mutate(r, "")
+		m.Feedback(sql, strings.Contains(sql, "WHERE"))
+	}
+	assert.Greater(t, where.Weight, g.Rules["WhereClauseOptional"][0].Weight)
+	assert.Equal(t, 1.0, g.Rules["Statement"][0].Weight)
+}
+
+func TestJoinTerminals(t *testing.T) {
+	assert.Equal(t, "SELECT count(*), t.a FROM t", joinTerminals([]string{"SELECT", "count", "(", "*", ")", ",", "t", ".", "a", "FROM", "t"}))
+}
@@ -0,0 +1,299 @@
+package sqlfuzz
+
+import (
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+)
+
+// a havoc mutation stacks 2 to 2^havocStackPow2 operations, like AFL
+const havocStackPow2 = 7
+
+// the max increment or decrement of arithmetic operations, like ARITH_MAX of AFL
+const arithMax = 35
+
+// inputs are never grown longer than it
+const maxHavocLen = 1 << 16
+
+// block lengths are chosen mostly small, like choose_block_len of AFL
+var blockLens = []int{32, 128, 1500}
+
+var (
+	interesting8  = []int8{-128, -1, 0, 1, 16, 32, 64, 100, 127}
+	interesting16 = []int16{-32768, -129, 128, 255, 256, 512, 1000, 1024, 4096, 32767}
+	interesting32 = []int32{-2147483648, -100663046, -32769, 32768, 65535, 65536, 100663045, 2147483647}
+)
+
+// havoc operations, named in the stats of campaigns
+var havocOps = []struct {
+	name   string
+	mutate func(h *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool)
+}{
+	{"flip_bit", flipBit},
+	{"interesting8", setInteresting8},
+	{"interesting16", setInteresting16},
+	{"interesting32", setInteresting32},
+	{"arith8", arith8},
+	{"arith16", arith16},
+	{"arith32", arith32},
+	{"random_byte", randomByte},
+	{"delete_block", deleteBlock},
+	{"clone_block", cloneBlock},
+	{"overwrite_block", overwriteBlock},
+	{"dict_overwrite", (*HavocMutator).dictOverwrite},
+	{"dict_insert", (*HavocMutator).dictInsert},
+}
+
+// HavocMutator is the havoc stage of AFL on the bytes of statements: random
+// operations are stacked, regardless of the syntax
+type HavocMutator struct {
+	*stageStats
+	tokens []string // non-empty ones of the dictionary, or keywords if there is none
+}
+
+func NewHavocMutator(tokens []dict.Token) *HavocMutator {
+	m := &HavocMutator{}
+	for _, t := range tokens {
+		if t.Value != "" {
+			m.tokens = append(m.tokens, t.Value)
+		}
+	}
+	if len(m.tokens) == 0 {
+		m.tokens = keywords
+	}
+	var names []string
+	for _, op := range havocOps {
+		names = append(names, op.name)
+	}
+	m.stageStats = newStageStats(names)
+	return m
+}
+
+func (m *HavocMutator) Mutate(r *rand.Rand, sql string) string {
+	b := []byte(sql)
+	if len(b) == 0 {
+		b = []byte(m.tokens[r.Intn(len(m.tokens))])
+	}
+	applied := make(map[int]bool)
+	var ops []int
+	for n := 1 << (1 + r.Intn(havocStackPow2)); n > 0; n-- {
+		i := r.Intn(len(havocOps))
+		res, ok := havocOps[i].mutate(m, r, b)
+		if !ok {
+			continue
+		}
+		b = res
+		if !applied[i] {
+			applied[i] = true
+			ops = append(ops, i)
+		}
+	}
+	m.applied(string(b), ops...)
+	return string(b)
+}
+
+func flipBit(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	bit := r.Intn(len(b) * 8)
+	b[bit/8] ^= 0x80 >> uint(bit%8)
+	return b, true
+}
+
+func setInteresting8(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	b[r.Intn(len(b))] = byte(interesting8[r.Intn(len(interesting8))])
+	return b, true
+}
+
+func setInteresting16(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	values := append(int8To16(), interesting16...)
+	putUint16(r, b[r.Intn(len(b)-1):], uint16(values[r.Intn(len(values))]))
+	return b, true
+}
+
+func setInteresting32(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 4 {
+		return nil, false
+	}
+	values := append([]int32(nil), interesting32...)
+	for _, v := range append(int8To16(), interesting16...) {
+		values = append(values, int32(v))
+	}
+	putUint32(r, b[r.Intn(len(b)-3):], uint32(values[r.Intn(len(values))]))
+	return b, true
+}
+
+func int8To16() []int16 {
+	res := make([]int16, len(interesting8))
+	for i, v := range interesting8 {
+		res[i] = int16(v)
+	}
+	return res
+}
+
+// arithDelta is a random non-zero delta in [-arithMax, arithMax]
+func arithDelta(r *rand.Rand) int {
+	if r.Intn(2) == 0 {
+		return 1 + r.Intn(arithMax)
+	}
+	return -1 - r.Intn(arithMax)
+}
+
+func arith8(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	pos := r.Intn(len(b))
+	b[pos] = byte(int(b[pos]) + arithDelta(r))
+	return b, true
+}
+
+func arith16(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	order := byteOrder(r)
+	pos := r.Intn(len(b) - 1)
+	order.PutUint16(b[pos:], uint16(int(order.Uint16(b[pos:]))+arithDelta(r)))
+	return b, true
+}
+
+func arith32(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 4 {
+		return nil, false
+	}
+	order := byteOrder(r)
+	pos := r.Intn(len(b) - 3)
+	order.PutUint32(b[pos:], uint32(int64(order.Uint32(b[pos:]))+int64(arithDelta(r))))
+	return b, true
+}
+
+// randomByte xors a byte with a non-zero value, so it always changes
+func randomByte(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	b[r.Intn(len(b))] ^= byte(1 + r.Intn(255))
+	return b, true
+}
+
+func deleteBlock(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	// never delete everything
+	n := blockLen(r, len(b)-1)
+	pos := r.Intn(len(b) - n + 1)
+	return append(b[:pos], b[pos+n:]...), true
+}
+
+// cloneBlock inserts a copy of a block, or sometimes a block of a constant byte
+func cloneBlock(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) >= maxHavocLen {
+		return nil, false
+	}
+	var block []byte
+	if len(b) > 0 && r.Intn(4) != 0 {
+		n := blockLen(r, len(b))
+		pos := r.Intn(len(b) - n + 1)
+		block = append([]byte(nil), b[pos:pos+n]...)
+	} else {
+		block = constBlock(r, b, blockLen(r, maxHavocLen-len(b)))
+	}
+	return insertBytes(b, r.Intn(len(b)+1), block), true
+}
+
+// overwriteBlock overwrites a block with another block, or sometimes with a
+// constant byte
+func overwriteBlock(_ *HavocMutator, r *rand.Rand, b []byte) ([]byte, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	n := blockLen(r, len(b)-1)
+	dst := r.Intn(len(b) - n + 1)
+	if r.Intn(4) != 0 {
+		src := r.Intn(len(b) - n + 1)
+		if src == dst {
+			return nil, false
+		}
+		copy(b[dst:dst+n], b[src:src+n])
+	} else {
+		copy(b[dst:dst+n], constBlock(r, b, n))
+	}
+	return b, true
+}
+
+func (m *HavocMutator) dictOverwrite(r *rand.Rand, b []byte) ([]byte, bool) {
+	token := m.tokens[r.Intn(len(m.tokens))]
+	if len(token) > len(b) || len(b) == 0 {
+		return nil, false
+	}
+	copy(b[r.Intn(len(b)-len(token)+1):], token)
+	return b, true
+}
+
+func (m *HavocMutator) dictInsert(r *rand.Rand, b []byte) ([]byte, bool) {
+	token := m.tokens[r.Intn(len(m.tokens))]
+	if len(b)+len(token) > maxHavocLen {
+		return nil, false
+	}
+	return insertBytes(b, r.Intn(len(b)+1), []byte(token)), true
+}
+
+// blockLen returns a random length in [1, limit], mostly not longer than 32
+func blockLen(r *rand.Rand, limit int) int {
+	max := blockLens[0]
+	switch r.Intn(10) {
+	case 0:
+		max = blockLens[2]
+	case 1, 2:
+		max = blockLens[1]
+	}
+	if max > limit {
+		max = limit
+	}
+	return 1 + r.Intn(max)
+}
+
+// constBlock is n of a random byte, or a byte of b if it's not empty
+func constBlock(r *rand.Rand, b []byte, n int) []byte {
+	c := byte(r.Intn(256))
+	if len(b) > 0 && r.Intn(2) == 0 {
+		c = b[r.Intn(len(b))]
+	}
+	block := make([]byte, n)
+	for i := range block {
+		block[i] = c
+	}
+	return block
+}
+
+func insertBytes(b []byte, pos int, inserted []byte) []byte {
+	res := make([]byte, 0, len(b)+len(inserted))
+	res = append(res, b[:pos]...)
+	res = append(res, inserted...)
+	return append(res, b[pos:]...)
+}
+
+func byteOrder(r *rand.Rand) binary.ByteOrder {
+	if r.Intn(2) == 0 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func putUint16(r *rand.Rand, b []byte, v uint16) {
+	byteOrder(r).PutUint16(b, v)
+}
+
+func putUint32(r *rand.Rand, b []byte, v uint32) {
+	byteOrder(r).PutUint32(b, v)
+}
@@ -0,0 +1,83 @@
+package sqlfuzz
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHavocOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewHavocMutator([]dict.Token{{Value: "SELECT", Count: 2}})
+	for _, op := range havocOps {
+		for _, sql := range []string{"", "a", "ab", "select a from t where b > 10"} {
+			for i := 0; i < 100; i++ {
+				b := []byte(sql)
+				res, ok := op.mutate(m, r, b)
+				if !ok {
+					continue
+				}
+				assert.NotEmpty(t, res, op.name)
+				if op.name == "delete_block" {
+					assert.Less(t, len(res), len(sql), op.name)
+				}
+				if op.name == "clone_block" || op.name == "dict_insert" {
+					assert.Greater(t, len(res), len(sql), op.name)
+				}
+			}
+		}
+	}
+
+	b := []byte("select")
+	res, ok := flipBit(m, r, b)
+	assert.True(t, ok)
+	diff := 0
+	for i := range res {
+		diff += bits.OnesCount8(res[i] ^ "select"[i])
+	}
+	assert.Equal(t, 1, diff)
+
+	res, ok = m.dictInsert(r, []byte("a"))
+	assert.True(t, ok)
+	assert.True(t, bytes.Contains(res, []byte("SELECT")))
+	_, ok = m.dictOverwrite(r, []byte("a"))
+	assert.False(t, ok)
+}
+
+func TestHavocMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewHavocMutator(nil)
+	assert.NotEmpty(t, m.Mutate(r, ""))
+	// empty tokens are never picked
+	assert.Equal(t, keywords, NewHavocMutator([]dict.Token{{Value: "", Count: 1}}).tokens)
+
+	sql := m.Mutate(r, "select a from t")
+	m.Feedback("another", true)
+	for _, s := range m.Stats() {
+		assert.Zero(t, s.Uses)
+	}
+	m.Feedback(sql, true)
+	uses, finds := uint64(0), uint64(0)
+	for _, s := range m.Stats() {
+		uses += s.Uses
+		finds += s.Finds
+	}
+	assert.Greater(t, uses, uint64(0))
+	assert.Equal(t, uses, finds)
+
+	// feedback is counted once
+	m.Feedback(sql, true)
+	total := uint64(0)
+	for _, s := range m.Stats() {
+		total += s.Uses
+	}
+	assert.Equal(t, uses, total)
+
+	m.Restore([]OperatorStats{{"flip_bit", 7, 2}, {"unknown", 1, 1}})
+	assert.Equal(t, OperatorStats{"flip_bit", 7, 2}, m.Stats()[0])
+	assert.Len(t, m.Stats(), len(havocOps))
+}
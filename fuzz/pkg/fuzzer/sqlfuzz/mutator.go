@@ -0,0 +1,245 @@
+package sqlfuzz
+
+import (
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+)
+
+// Mutator derives a new input from an input in corpus
+type Mutator interface {
+	Mutate(r *rand.Rand, sql string) string
+}
+
+// Learner is a Mutator learning from inputs added to corpus
+type Learner interface {
+	Learn(sql string)
+}
+
+// Feedback is a Mutator learning whether its last mutation is interesting,
+// i.e. it hits new coverage, crashes tidb or makes it panic
+type Feedback interface {
+	Feedback(sql string, interesting bool)
+}
+
+// OperatorStats counts mutations an operator is applied in, and the ones
+// hitting new coverage
+type OperatorStats struct {
+	Name  string
+	Uses  uint64
+	Finds uint64
+}
+
+// stageStats counts operators of a stage like havoc by the feedback of the
+// last mutation
+type stageStats struct {
+	mu      sync.Mutex
+	stats   []OperatorStats
+	last    string
+	lastOps []int // operators applied by the last mutation, waiting for feedback
+}
+
+func newStageStats(names []string) *stageStats {
+	s := &stageStats{}
+	for _, name := range names {
+		s.stats = append(s.stats, OperatorStats{Name: name})
+	}
+	return s
+}
+
+// applied records sql is derived by the operators at indexes ops
+func (s *stageStats) applied(sql string, ops ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last, s.lastOps = sql, ops
+}
+
+func (s *stageStats) Feedback(sql string, interesting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sql != s.last || s.lastOps == nil {
+		return
+	}
+	for _, i := range s.lastOps {
+		s.stats[i].Uses++
+		if interesting {
+			s.stats[i].Finds++
+		}
+	}
+	s.lastOps = nil
+}
+
+// Stats returns the counters of every operator
+func (s *stageStats) Stats() []OperatorStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]OperatorStats(nil), s.stats...)
+}
+
+// Restore sets counters saved by a previous run, unknown operators are
+// ignored
+func (s *stageStats) Restore(stats []OperatorStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, saved := range stats {
+		for i := range s.stats {
+			if s.stats[i].Name == saved.Name {
+				s.stats[i].Uses, s.stats[i].Finds = saved.Uses, saved.Finds
+			}
+		}
+	}
+}
+
+// interesting values replacing numbers, mostly boundaries of types
+var interestingNumbers = []string{
+	"0", "1", "-1", "127", "128", "255", "256", "-128", "32767", "65535",
+	"2147483647", "-2147483648", "4294967295", "9223372036854775807",
+	"-9223372036854775808", "18446744073709551615", "1e308", "0.1", "NULL",
+}
+
+var keywords = []string{
+	"SELECT", "FROM", "WHERE", "NOT", "NULL", "AND", "OR", "GROUP BY", "ORDER BY",
+	"LIMIT", "JOIN", "UNION", "DISTINCT", "HAVING", "IN", "BETWEEN", "LIKE", "IS",
+	"CASE", "WHEN", "THEN", "ELSE", "END", "(", ")", ",", "*", "+", "-", "=", "<",
+}
+
+// TextMutator mutates the text of statements without parsing them, tokens
+// of the dictionary are inserted and replace words if it's not empty
+type TextMutator struct {
+	tokens  []string
+	weights []int // cumulative; a token found 2^n times weighs n+1 times a token found once
+}
+
+func NewTextMutator(tokens []dict.Token) *TextMutator {
+	m := &TextMutator{}
+	total := 0
+	for _, t := range tokens {
+		total += bits.Len(uint(t.Count))
+		m.tokens = append(m.tokens, t.Value)
+		m.weights = append(m.weights, total)
+	}
+	return m
+}
+
+// token returns a random token of the dictionary, or a keyword if it's empty
+func (m *TextMutator) token(r *rand.Rand) string {
+	if len(m.tokens) == 0 {
+		return keywords[r.Intn(len(keywords))]
+	}
+	w := r.Intn(m.weights[len(m.weights)-1])
+	return m.tokens[sort.SearchInts(m.weights, w+1)]
+}
+
+func (m *TextMutator) Mutate(r *rand.Rand, sql string) string {
+	if sql == "" {
+		return m.token(r)
+	}
+	n := 5
+	if len(m.tokens) > 0 {
+		n = 7
+	}
+	switch r.Intn(n) {
+	case 0:
+		if res, ok := replaceNumber(r, sql); ok {
+			return res
+		}
+		fallthrough
+	case 1:
+		// insert a keyword at a space
+		pos := randomSpace(r, sql)
+		return sql[:pos] + " " + keywords[r.Intn(len(keywords))] + " " + sql[pos:]
+	case 2:
+		start, end := randomRange(r, sql)
+		return sql[:start] + sql[end:]
+	case 3:
+		start, end := randomRange(r, sql)
+		return sql[:end] + sql[start:end] + sql[end:]
+	case 5:
+		// insert a token of the dictionary at a space
+		pos := randomSpace(r, sql)
+		return sql[:pos] + " " + m.token(r) + " " + sql[pos:]
+	case 6:
+		if start, end, ok := randomWord(r, sql); ok {
+			return sql[:start] + m.token(r) + sql[end:]
+		}
+		return sql + " " + m.token(r)
+	default:
+		pos := r.Intn(len(sql))
+		return sql[:pos] + string(rune(' '+r.Intn('~'-' '+1))) + sql[pos+1:]
+	}
+}
+
+// replaceNumber replaces a random number in sql with an interesting value
+func replaceNumber(r *rand.Rand, sql string) (string, bool) {
+	var starts []int
+	for i := 0; i < len(sql); i++ {
+		if isDigit(sql[i]) && (i == 0 || !isIdentChar(sql[i-1]) && sql[i-1] != '.') {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		return sql, false
+	}
+	start := starts[r.Intn(len(starts))]
+	end := start
+	for end < len(sql) && (isDigit(sql[end]) || sql[end] == '.') {
+		end++
+	}
+	return sql[:start] + interestingNumbers[r.Intn(len(interestingNumbers))] + sql[end:], true
+}
+
+// randomSpace returns the position of a random space, or the end of sql
+func randomSpace(r *rand.Rand, sql string) int {
+	var spaces []int
+	for i := 0; i < len(sql); i++ {
+		if sql[i] == ' ' {
+			spaces = append(spaces, i)
+		}
+	}
+	if len(spaces) == 0 {
+		return len(sql)
+	}
+	return spaces[r.Intn(len(spaces))]
+}
+
+// randomWord returns the range of a random word in sql
+func randomWord(r *rand.Rand, sql string) (int, int, bool) {
+	var starts []int
+	for i := 0; i < len(sql); i++ {
+		if isIdentChar(sql[i]) && !isDigit(sql[i]) && (i == 0 || !isIdentChar(sql[i-1])) {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		return 0, 0, false
+	}
+	start := starts[r.Intn(len(starts))]
+	end := start
+	for end < len(sql) && isIdentChar(sql[end]) {
+		end++
+	}
+	return start, end, true
+}
+
+// randomRange returns a non-empty range of sql; sql must not be empty
+func randomRange(r *rand.Rand, sql string) (int, int) {
+	start := r.Intn(len(sql))
+	end := start + 1 + r.Intn(len(sql)-start)
+	// prefer whole words
+	if i := strings.IndexByte(sql[start:end], ' '); i > 0 && r.Intn(2) == 0 {
+		end = start + i
+	}
+	return start, end
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
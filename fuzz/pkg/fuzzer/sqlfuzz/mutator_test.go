@@ -0,0 +1,81 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := TextMutator{}
+
+	assert.Contains(t, keywords, m.Mutate(r, ""))
+
+	sql := "select a from t where b > 10"
+	changed := 0
+	for i := 0; i < 1000; i++ {
+		res := m.Mutate(r, sql)
+		if res != sql {
+			changed++
+		}
+	}
+	// replacing a char with itself may keep the input
+	assert.Greater(t, changed, 900)
+}
+
+func TestReplaceNumber(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	_, ok := replaceNumber(r, "select a1 from t")
+	assert.False(t, ok)
+
+	for i := 0; i < 100; i++ {
+		res, ok := replaceNumber(r, "select 1.5")
+		assert.True(t, ok)
+		assert.Contains(t, interestingNumbers, res[len("select "):])
+	}
+}
+
+func TestRandomRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sql := "select 1"
+	for i := 0; i < 100; i++ {
+		start, end := randomRange(r, sql)
+		assert.True(t, start >= 0 && start < end && end <= len(sql))
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := NewCorpus()
+	assert.Nil(t, c.Pick(r))
+
+	c.Add(&Input{SQL: "select 1"})
+	c.Add(&Input{SQL: "select 2"})
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, "select 1", c.Inputs()[0].SQL)
+	assert.NotNil(t, c.Pick(r))
+}
+
+func TestDictMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewTextMutator([]dict.Token{{Value: "tidb_snapshot", Count: 1000}, {Value: "abs", Count: 1}})
+
+	picked := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		picked[m.token(r)]++
+	}
+	// 1000 weighs 10 times of 1
+	assert.Greater(t, picked["tidb_snapshot"], picked["abs"]*5)
+	assert.Greater(t, picked["abs"], 0)
+
+	start, end, ok := randomWord(r, "select 1")
+	assert.True(t, ok)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 6, end)
+	_, _, ok = randomWord(r, "1 + 2")
+	assert.False(t, ok)
+}
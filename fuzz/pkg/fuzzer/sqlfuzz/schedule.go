@@ -0,0 +1,343 @@
+package sqlfuzz
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schedule is a power schedule assigning energy to inputs of the queue,
+// like the ones of AFLFast
+type Schedule int
+
+const (
+	ExploreSchedule Schedule = iota // energy by speed, coverage and depth only, like AFL
+	FastSchedule                    // more energy for inputs of rarely hit paths, growing as they're picked
+	CoeSchedule                     // like fast, but inputs of paths hit more than average are skipped
+	ExploitSchedule                 // the max factor for every input
+	RareSchedule                    // more energy for inputs hitting edges few other inputs hit
+)
+
+var scheduleNames = []string{"explore", "fast", "coe", "exploit", "rare"}
+
+func (s Schedule) String() string {
+	return scheduleNames[s]
+}
+
+// ParseSchedule returns the schedule of name, or fast if it's empty
+func ParseSchedule(name string) (Schedule, error) {
+	if name == "" {
+		return FastSchedule, nil
+	}
+	for i, n := range scheduleNames {
+		if n == name {
+			return Schedule(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown schedule %s, should be one of %s", name, strings.Join(scheduleNames, ", "))
+}
+
+// an input of perf score 100 is mutated this many times when picked
+const baseEnergy = 16
+
+// like HAVOC_MAX_MULT of AFL, perf scores are at most 100*maxPerfMult
+const maxPerfMult = 64
+
+// like MAX_FACTOR of AFLFast
+const maxFactor = 32
+
+// Scheduler picks inputs of corpus in cycles like AFL: favored inputs,
+// which cover all known edges with the fastest and shortest inputs, are
+// preferred, and others are mostly skipped
+type Scheduler struct {
+	schedule Schedule
+	corpus   *Corpus
+
+	mu        sync.Mutex
+	next      int               // index in corpus of the next input to consider
+	pathHits  map[uint64]uint64 // executions of every path
+	edgeHits  map[int]int       // inputs of corpus hitting every edge
+	topRated  map[int]*Input    // the input with the lowest cost hitting every edge
+	dirty     bool              // topRated changed since the last culling
+	favored   int
+	pending   int // favored inputs never picked
+	scoreBase scoreBase
+}
+
+// averages of corpus for scoring
+type scoreBase struct {
+	execTime float64
+	edges    float64
+	size     float64
+	pathHits float64 // executions of paths of inputs
+}
+
+func NewScheduler(schedule Schedule, corpus *Corpus) *Scheduler {
+	return &Scheduler{
+		schedule: schedule,
+		corpus:   corpus,
+		pathHits: make(map[uint64]uint64),
+		edgeHits: make(map[int]int),
+		topRated: make(map[int]*Input),
+	}
+}
+
+// Add updates edges of input, it must be added to corpus too
+func (s *Scheduler) Add(input *Input) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range input.Edges {
+		s.edgeHits[e]++
+		if top, ok := s.topRated[e]; !ok || input.cost() < top.cost() {
+			s.topRated[e] = input
+			s.dirty = true
+		}
+	}
+}
+
+// Executed counts an execution of the path
+func (s *Scheduler) Executed(path uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathHits[path]++
+}
+
+// Favored returns how many inputs are favored
+func (s *Scheduler) Favored() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.favored
+}
+
+// Next returns the next input to fuzz with its energy, i.e. how many times
+// it's mutated; it returns nil if corpus is empty
+func (s *Scheduler) Next(r *rand.Rand) (*Input, int) {
+	inputs := s.corpus.Inputs()
+	if len(inputs) == 0 {
+		return nil, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty {
+		s.cull(inputs)
+	}
+	s.scoreBase = s.averages(inputs)
+	for {
+		if s.next >= len(inputs) {
+			s.next = 0
+		}
+		input := inputs[s.next]
+		s.next++
+		if s.skip(r, input) {
+			continue
+		}
+		energy := s.energy(input)
+		input.Fuzzed++
+		if input.Favored && input.Fuzzed == 1 {
+			s.pending--
+		}
+		if energy > 0 {
+			return input, energy
+		}
+	}
+}
+
+// skip mostly skips inputs not favored like AFL, and always skips inputs
+// with favored ones pending
+func (s *Scheduler) skip(r *rand.Rand, input *Input) bool {
+	switch {
+	case s.pending > 0 && (input.Fuzzed > 0 || !input.Favored):
+		return r.Intn(100) < 99
+	case !input.Favored && s.favored > 0 && input.Fuzzed == 0:
+		return r.Intn(100) < 75
+	case !input.Favored && s.favored > 0:
+		return r.Intn(100) < 95
+	}
+	return false
+}
+
+// cull marks a minimal set of inputs covering all known edges as favored,
+// preferring the top rated one of every edge like cull_queue of AFL
+func (s *Scheduler) cull(inputs []*Input) {
+	edges := make([]int, 0, len(s.topRated))
+	for e := range s.topRated {
+		edges = append(edges, e)
+	}
+	sort.Ints(edges)
+	for _, input := range inputs {
+		input.Favored = false
+	}
+	covered := make(map[int]bool)
+	s.favored, s.pending = 0, 0
+	for _, e := range edges {
+		top := s.topRated[e]
+		if covered[e] || top.Favored {
+			continue
+		}
+		top.Favored = true
+		s.favored++
+		if top.Fuzzed == 0 {
+			s.pending++
+		}
+		for _, covers := range top.Edges {
+			covered[covers] = true
+		}
+	}
+	s.dirty = false
+}
+
+func (s *Scheduler) averages(inputs []*Input) scoreBase {
+	var b scoreBase
+	for _, input := range inputs {
+		b.execTime += float64(input.ExecTime)
+		b.edges += float64(len(input.Edges))
+		b.size += float64(len(input.SQL))
+		b.pathHits += float64(s.pathHits[input.Path])
+	}
+	n := float64(len(inputs))
+	return scoreBase{b.execTime / n, b.edges / n, b.size / n, b.pathHits / n}
+}
+
+// energy is baseEnergy times the perf score like calculate_score of AFL, and
+// the factor of the schedule
+func (s *Scheduler) energy(input *Input) int {
+	perf := perfScore(input, s.scoreBase)
+	factor := 1.0
+	hits := float64(s.pathHits[input.Path])
+	if hits == 0 {
+		hits = 1
+	}
+	switch s.schedule {
+	case ExploitSchedule:
+		factor = maxFactor
+	case CoeSchedule:
+		if hits > s.scoreBase.pathHits {
+			// inputs never picked get a chance anyway
+			if input.Fuzzed > 0 {
+				return 0
+			}
+			break
+		}
+		fallthrough
+	case FastSchedule:
+		if input.Fuzzed < 16 {
+			factor = float64(uint64(1)<<uint(input.Fuzzed)) / hits
+		} else {
+			factor = maxFactor / hits
+		}
+	case RareSchedule:
+		factor = maxFactor / float64(s.rarestEdge(input))
+	}
+	if factor > maxFactor {
+		factor = maxFactor
+	}
+	perf *= factor
+	if perf > 100*maxPerfMult {
+		perf = 100 * maxPerfMult
+	}
+	energy := int(baseEnergy * perf / 100)
+	if energy < 1 && factor > 0 {
+		energy = 1
+	}
+	return energy
+}
+
+// rarestEdge returns how many inputs hit the rarest edge of input
+func (s *Scheduler) rarestEdge(input *Input) int {
+	rarest := 0
+	for _, e := range input.Edges {
+		if hits := s.edgeHits[e]; rarest == 0 || hits < rarest {
+			rarest = hits
+		}
+	}
+	if rarest == 0 {
+		return 1
+	}
+	return rarest
+}
+
+// perfScore rewards fast, high coverage, short and deep inputs, 100 for an
+// average one
+func perfScore(input *Input, avg scoreBase) float64 {
+	perf := 100.0
+	execTime := float64(input.ExecTime)
+	switch {
+	case execTime*0.1 > avg.execTime:
+		perf = 10
+	case execTime*0.25 > avg.execTime:
+		perf = 25
+	case execTime*0.5 > avg.execTime:
+		perf = 50
+	case execTime*0.75 > avg.execTime:
+		perf = 75
+	case execTime*4 < avg.execTime:
+		perf = 300
+	case execTime*3 < avg.execTime:
+		perf = 200
+	case execTime*2 < avg.execTime:
+		perf = 150
+	}
+
+	edges := float64(len(input.Edges))
+	switch {
+	case edges*0.3 > avg.edges:
+		perf *= 3
+	case edges*0.5 > avg.edges:
+		perf *= 2
+	case edges*0.75 > avg.edges:
+		perf *= 1.5
+	case edges*3 < avg.edges:
+		perf *= 0.25
+	case edges*2 < avg.edges:
+		perf *= 0.5
+	case edges*1.5 < avg.edges:
+		perf *= 0.75
+	}
+
+	size := float64(len(input.SQL))
+	switch {
+	case size > avg.size*4:
+		perf *= 0.5
+	case size*4 < avg.size:
+		perf *= 1.5
+	}
+
+	switch {
+	case input.Depth <= 3:
+	case input.Depth <= 7:
+		perf *= 2
+	case input.Depth <= 13:
+		perf *= 3
+	case input.Depth <= 25:
+		perf *= 4
+	default:
+		perf *= 5
+	}
+	return perf
+}
+
+// cost of an input, the lower the better for top rated inputs, like
+// exec_us * len of AFL
+func (input *Input) cost() float64 {
+	return float64(input.ExecTime) * float64(len(input.SQL)+1)
+}
+
+// edgesOf returns indexes of edges hit in bits, and the hash of them as the
+// path of the execution
+func edgesOf(bits []byte) ([]int, uint64) {
+	var edges []int
+	h := fnv.New64a()
+	var buf [4]byte
+	for i, b := range bits {
+		if b == 0 {
+			continue
+		}
+		edges = append(edges, i)
+		buf[0], buf[1], buf[2], buf[3] = byte(i), byte(i>>8), byte(i>>16), byte(i>>24)
+		h.Write(buf[:])
+	}
+	return edges, h.Sum64()
+}
@@ -0,0 +1,112 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("")
+	assert.Nil(t, err)
+	assert.Equal(t, FastSchedule, s)
+	s, err = ParseSchedule("rare")
+	assert.Nil(t, err)
+	assert.Equal(t, RareSchedule, s)
+	assert.Equal(t, "rare", s.String())
+	_, err = ParseSchedule("slow")
+	assert.NotNil(t, err)
+}
+
+func TestCull(t *testing.T) {
+	corpus := NewCorpus()
+	s := NewScheduler(ExploreSchedule, corpus)
+	inputs := []*Input{
+		{SQL: "select 1", ExecTime: time.Millisecond, Edges: []int{1, 2}},
+		{SQL: "select 1, 2", ExecTime: time.Millisecond, Edges: []int{1, 2, 3}},
+		{SQL: "select 3", ExecTime: time.Millisecond, Edges: []int{3}},
+		// slower, so never favored
+		{SQL: "select 4", ExecTime: time.Second, Edges: []int{2, 3}},
+	}
+	for _, input := range inputs {
+		corpus.Add(input)
+		s.Add(input)
+	}
+	s.cull(corpus.Inputs())
+	assert.True(t, inputs[0].Favored)
+	assert.False(t, inputs[1].Favored)
+	assert.True(t, inputs[2].Favored)
+	assert.False(t, inputs[3].Favored)
+	assert.Equal(t, 2, s.favored)
+	assert.Equal(t, 2, s.pending)
+
+	// favored inputs are picked first
+	r := rand.New(rand.NewSource(1))
+	picked := make(map[*Input]bool)
+	for i := 0; i < 2; i++ {
+		input, energy := s.Next(r)
+		assert.Greater(t, energy, 0)
+		picked[input] = true
+	}
+	assert.Equal(t, map[*Input]bool{inputs[0]: true, inputs[2]: true}, picked)
+	assert.Equal(t, 0, s.pending)
+
+	// a faster input of edge 1 replaces the top rated one
+	faster := &Input{SQL: "select 5", ExecTime: time.Microsecond, Edges: []int{1, 2, 3}}
+	corpus.Add(faster)
+	s.Add(faster)
+	s.Next(r)
+	assert.True(t, faster.Favored)
+	assert.Equal(t, 1, s.Favored())
+}
+
+func TestEnergy(t *testing.T) {
+	avg := scoreBase{execTime: float64(time.Millisecond), edges: 10, size: 10}
+	input := &Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: make([]int, 10), Depth: 1}
+	assert.Equal(t, 100.0, perfScore(input, avg))
+	slow := &Input{SQL: "select 1", ExecTime: time.Second, Edges: make([]int, 10), Depth: 1}
+	assert.Equal(t, 10.0, perfScore(slow, avg))
+	deep := &Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: make([]int, 40), Depth: 10}
+	assert.Equal(t, 900.0, perfScore(deep, avg))
+
+	s := NewScheduler(FastSchedule, NewCorpus())
+	// 1 or 2 edges are average
+	s.scoreBase = scoreBase{execTime: float64(time.Millisecond), edges: 1.5, size: 10}
+	rare := &Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: []int{1}, Path: 1}
+	common := &Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: []int{1}, Path: 2}
+	s.pathHits[1], s.pathHits[2] = 1, 8
+	assert.Equal(t, baseEnergy, s.energy(rare))
+	assert.Equal(t, baseEnergy/8, s.energy(common))
+	// energy grows as inputs are picked
+	common.Fuzzed = 5
+	assert.Equal(t, baseEnergy*4, s.energy(common))
+
+	s.schedule = CoeSchedule
+	s.scoreBase.pathHits = 4
+	assert.Equal(t, 0, s.energy(common))
+	assert.Equal(t, baseEnergy, s.energy(rare))
+
+	s.schedule = RareSchedule
+	s.edgeHits[1] = 1
+	s.edgeHits[2] = 4
+	assert.Equal(t, baseEnergy*maxFactor, s.energy(&Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: []int{1, 2}}))
+	assert.Equal(t, baseEnergy*maxFactor/4, s.energy(&Input{SQL: "select 1", ExecTime: time.Millisecond, Edges: []int{2}}))
+
+	s.schedule = ExploitSchedule
+	assert.Equal(t, baseEnergy*maxFactor, s.energy(rare))
+}
+
+func TestEdgesOf(t *testing.T) {
+	bits := make([]byte, 16)
+	bits[3], bits[9] = 1, 200
+	edges, path := edgesOf(bits)
+	assert.Equal(t, []int{3, 9}, edges)
+	bits[9] = 1
+	_, same := edgesOf(bits)
+	assert.Equal(t, path, same)
+	bits[10] = 1
+	_, other := edgesOf(bits)
+	assert.NotEqual(t, path, other)
+}
@@ -0,0 +1,305 @@
+package sqlfuzz
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Script is the statements of a test, executed as one input of the fuzzer
+type Script struct {
+	Name       string
+	Statements []string
+}
+
+// methods of tidb testkit taking statements, with the number of arguments
+// after the statement; calls with more arguments have placeholders.
+// Statements expected to fail, like of `ExecToErr` and `MustGetErrCode`, are
+// left out, or scripts would abort at them
+var testkitMethods = map[string]int{
+	"MustExec":     0,
+	"MustQuery":    0,
+	"HasPlan":      1,
+	"MustUseIndex": 1,
+}
+
+// commands of mysqltest, not sent to the server
+var mysqlTestCommands = map[string]bool{
+	"disable_warnings": true, "enable_warnings": true, "disable_query_log": true, "enable_query_log": true,
+	"disable_result_log": true, "enable_result_log": true, "disable_info": true, "enable_info": true,
+	"disable_abort_on_error": true, "enable_abort_on_error": true, "vertical_results": true,
+	"horizontal_results": true, "query_vertical": true, "sorted_result": true, "replace_regex": true,
+	"replace_column": true, "replace_result": true, "echo": true, "source": true, "let": true, "eval": true,
+	"error": true, "connect": true, "connection": true, "disconnect": true, "sleep": true, "real_sleep": true,
+	"send": true, "reap": true, "exit": true, "skip": true, "die": true, "inc": true, "dec": true,
+	"while": true, "if": true, "end": true, "result_format": true,
+}
+
+// ImportSeeds extracts statements from tests in a tidb checkout: string
+// literals passed to testkit in `_test.go` files, and mysqltest `.test`
+// files; every test function or `.test` file becomes a script
+func ImportSeeds(root string) ([]*Script, error) {
+	var res []*Script
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			// like go tools, skip vendor and dirs starting with `.` or `_`
+			if path != root && (name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(name, "_test.go"):
+			scripts, err := goTestScripts(path, filepath.ToSlash(rel))
+			if err != nil {
+				// some test data is not valid go
+				log.Printf("skip %s: %v", rel, err)
+				return nil
+			}
+			res = append(res, scripts...)
+		case strings.HasSuffix(name, ".test"):
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if stmts := parseMySQLTest(string(src)); len(stmts) > 0 {
+				res = append(res, &Script{Name: filepath.ToSlash(rel), Statements: stmts})
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// goTestScripts returns a script for every test function calling testkit
+// with literal statements
+func goTestScripts(path, rel string) ([]*Script, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Script
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || !strings.HasPrefix(fn.Name.Name, "Test") {
+			continue
+		}
+		script := &Script{Name: rel + ":" + testName(fn)}
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			extra, ok := testkitMethods[sel.Sel.Name]
+			if !ok || len(call.Args) > 1+extra {
+				return true
+			}
+			if stmt, ok := stringLit(call.Args[0]); ok {
+				stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+				if stmt != "" {
+					script.Statements = append(script.Statements, stmt)
+				}
+			}
+			return true
+		})
+		if len(script.Statements) > 0 {
+			res = append(res, script)
+		}
+	}
+	return res, nil
+}
+
+// testName is like `testSuite.TestSelect` for methods of gocheck suites
+func testName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name + "." + fn.Name.Name
+	}
+	return fn.Name.Name
+}
+
+// stringLit evaluates string literals and concatenations of them
+func stringLit(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		res, err := strconv.Unquote(e.Value)
+		return res, err == nil
+	case *ast.ParenExpr:
+		return stringLit(e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringLit(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringLit(e.Y)
+		return x + y, ok
+	}
+	return "", false
+}
+
+// parseMySQLTest returns the statements of a mysqltest file, skipping
+// comments, mysqltest commands and statements expected to fail by `--error`
+func parseMySQLTest(src string) []string {
+	var res []string
+	delimiter := ";"
+	expectError := false
+	var buf strings.Builder
+	add := func() {
+		stmt := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(buf.String()), delimiter))
+		buf.Reset()
+		fields := strings.Fields(stmt)
+		if len(fields) == 0 {
+			return
+		}
+		switch command := strings.ToLower(commandName(fields[0])); {
+		case command == "delimiter":
+			if len(fields) > 1 {
+				delimiter = fields[1]
+			}
+		case command == "error":
+			expectError = true
+		case mysqlTestCommands[command]:
+		case expectError:
+			expectError = false
+		default:
+			res = append(res, stmt)
+		}
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if buf.Len() == 0 {
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			// commands like `--error 1064` take the whole line
+			if strings.HasPrefix(trimmed, "--") {
+				if fields := strings.Fields(trimmed[2:]); len(fields) > 1 && fields[0] == "delimiter" {
+					delimiter = fields[1]
+				} else if len(fields) > 0 && fields[0] == "error" {
+					expectError = true
+				}
+				continue
+			}
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		if strings.HasSuffix(trimmed, delimiter) {
+			add()
+		}
+	}
+	add()
+	return res
+}
+
+// commandName is the leading word, like `connect` of `connect (conn1,...)`
+func commandName(word string) string {
+	for i := 0; i < len(word); i++ {
+		if !isIdentChar(word[i]) {
+			return word[:i]
+		}
+	}
+	return word
+}
+
+// WriteSeeds writes every script into a `.sql` file in dir
+func WriteSeeds(dir string, scripts []*Script) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	escape := strings.NewReplacer("/", "_", ":", "-", ".", "_")
+	used := make(map[string]bool)
+	for _, script := range scripts {
+		name := escape.Replace(script.Name)
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%s-%d", escape.Replace(script.Name), i)
+		}
+		used[name] = true
+
+		content := strings.Join(script.Statements, ";\n") + ";\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".sql"), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSeeds reads initial inputs from path: every `.sql` file is an input
+// if path is a directory, otherwise every non-empty line is an input,
+// skipping `--` and `#` comments
+func LoadSeeds(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(path, "*.sql"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		res := make([]string, 0, len(files))
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if seed := strings.TrimSpace(string(content)); seed != "" {
+				res = append(res, seed)
+			}
+		}
+		return res, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var res []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "--") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res, scanner.Err()
+}
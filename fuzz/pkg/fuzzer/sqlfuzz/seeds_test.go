@@ -0,0 +1,105 @@
+package sqlfuzz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var goTestSrc = "package executor\n" + `
+func (s *testSuite) TestSelect(c *C) {
+	tk := testkit.NewTestKit(c, s.store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int);")
+	tk.MustQuery("select * from t where a = " + "1").Check(testkit.Rows())
+	tk.MustQuery("select * from t where a = ?", 1)
+	tk.MustQuery(` + "`select a\nfrom t`" + `)
+	tk.MustGetErrCode("select b from t", mysql.ErrBadField)
+	_, err := tk.ExecToErr("insert into t values ('x')")
+	for _, sql := range []string{"select 1"} {
+		tk.MustExec(sql)
+	}
+}
+
+func TestEmpty(t *testing.T) {}
+
+func helper(tk *testkit.TestKit) {
+	tk.MustExec("select 2")
+}
+`
+
+var mysqlTestSrc = `# comment
+--disable_warnings
+drop table if exists t;
+--enable_warnings
+create table t (
+  a int
+);
+--error 1054
+select b from t;
+error 1064;
+selec 1;
+connect (conn1, localhost, root,,);
+delimiter //;
+create procedure p() begin select 1; end//
+delimiter ;//
+select 1
+`
+
+func TestImportSeeds(t *testing.T) {
+	root, err := ioutil.TempDir("", "tidb")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"executor/executor_test.go":     goTestSrc,
+		"executor/bad_test.go":          "not go",
+		"cmd/explaintest/t/select.test": mysqlTestSrc,
+		"vendor/x/x_test.go":            goTestSrc,
+	}
+	for name, src := range files {
+		path := filepath.Join(root, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(src), 0644))
+	}
+
+	scripts, err := ImportSeeds(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(scripts))
+	assert.Equal(t, "cmd/explaintest/t/select.test", scripts[0].Name)
+	assert.Equal(t, []string{
+		"drop table if exists t",
+		"create table t (\n  a int\n)",
+		"create procedure p() begin select 1; end",
+		"select 1",
+	}, scripts[0].Statements)
+	assert.Equal(t, "executor/executor_test.go:testSuite.TestSelect", scripts[1].Name)
+	assert.Equal(t, []string{
+		"use test",
+		"create table t (a int)",
+		"select * from t where a = 1",
+		"select a\nfrom t",
+	}, scripts[1].Statements)
+
+	out := filepath.Join(root, "seeds")
+	assert.Nil(t, WriteSeeds(out, scripts))
+	seeds, err := LoadSeeds(out)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(seeds))
+	assert.Equal(t, "use test;\ncreate table t (a int);\nselect * from t where a = 1;\nselect a\nfrom t;", seeds[1])
+}
+
+func TestLoadSeedsFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "seeds")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("-- comment\nselect 1\n\n# comment\nselect 2; select 3\n")
+	file.Close()
+
+	seeds, err := LoadSeeds(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"select 1", "select 2; select 3"}, seeds)
+}
@@ -0,0 +1,184 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+)
+
+// clauses crossed by splicing, besides the ones in clauseKeywords
+var spliceKeywords = append([]string{"FROM", "SET", "VALUES"}, clauseKeywords...)
+
+// splice operators, named in the stats of campaigns
+var spliceOps = []struct {
+	name   string
+	splice func(r *rand.Rand, a, b []Token) ([]Token, bool)
+}{
+	{"statement", spliceStatements},
+	{"clause", spliceClauses},
+	{"token", spliceTokens},
+}
+
+// SpliceMutator crosses an input with another one in corpus, like splicing
+// of AFL but at boundaries of statements, clauses or tokens; e.g. the WHERE
+// clause of a query is put after the FROM clause of another
+type SpliceMutator struct {
+	*stageStats
+	corpus   *Corpus
+	fallback Mutator // if corpus has no other inputs
+}
+
+func NewSpliceMutator(corpus *Corpus, fallback Mutator) *SpliceMutator {
+	var names []string
+	for _, op := range spliceOps {
+		names = append(names, op.name)
+	}
+	return &SpliceMutator{stageStats: newStageStats(names), corpus: corpus, fallback: fallback}
+}
+
+func (m *SpliceMutator) Mutate(r *rand.Rand, sql string) string {
+	var other string
+	for try := 0; try < maxTokenTries && other == ""; try++ {
+		if input := m.corpus.Pick(r); input != nil && input.SQL != sql {
+			other = input.SQL
+		}
+	}
+	if other != "" {
+		a, b := Tokenize(sql), Tokenize(other)
+		for _, i := range r.Perm(len(spliceOps)) {
+			if res, ok := spliceOps[i].splice(r, a, b); ok {
+				spliced := Render(res)
+				m.applied(spliced, i)
+				return spliced
+			}
+		}
+	}
+	return m.fallback.Mutate(r, sql)
+}
+
+// spliceStatements joins the leading statements of a with the trailing ones
+// of b, if either has more than one statement
+func spliceStatements(r *rand.Rand, a, b []Token) ([]Token, bool) {
+	endsA, startsB := statementEnds(a), statementStarts(b)
+	if len(endsA) < 2 && len(startsB) < 2 {
+		return nil, false
+	}
+	head := a[:endsA[r.Intn(len(endsA))]]
+	tail := b[startsB[r.Intn(len(startsB))]:]
+	res := append([]Token(nil), head...)
+	if !endsWithSemi(head) {
+		res = append(res, Token{Kind: PunctToken, Text: ";"})
+	}
+	if len(tail) > 0 && tail[0].Kind != SpaceToken {
+		res = append(res, Token{Kind: SpaceToken, Text: " "})
+	}
+	return append(res, tail...), true
+}
+
+// statementEnds returns positions after every `;` at depth 0, and the end
+func statementEnds(tokens []Token) []int {
+	var res []int
+	for _, i := range semis(tokens) {
+		res = append(res, i+1)
+	}
+	if len(res) == 0 || res[len(res)-1] != len(tokens) {
+		res = append(res, len(tokens))
+	}
+	return res
+}
+
+// statementStarts returns 0 and positions after every `;` at depth 0
+// followed by another statement
+func statementStarts(tokens []Token) []int {
+	res := []int{0}
+	for _, i := range semis(tokens) {
+		for j := i + 1; j < len(tokens); j++ {
+			if tokens[j].significant() {
+				res = append(res, i+1)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func semis(tokens []Token) []int {
+	var res []int
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.Text == "(":
+			depth++
+		case t.Text == ")" && depth > 0:
+			depth--
+		case t.Text == ";" && depth == 0:
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+func endsWithSemi(tokens []Token) bool {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if tokens[i].significant() {
+			return tokens[i].Text == ";"
+		}
+	}
+	return true
+}
+
+// spliceClauses replaces a clause of a with the clause of b started by the
+// same keyword, or joins a before the clause with b from the clause
+func spliceClauses(r *rand.Rand, a, b []Token) ([]Token, bool) {
+	posA := keywordPositions(a, spliceKeywords)
+	if len(posA) == 0 {
+		return nil, false
+	}
+	for _, i := range r.Perm(len(posA)) {
+		start := posA[i]
+		posB := keywordPositions(b, []string{a[start].Text})
+		if len(posB) == 0 {
+			continue
+		}
+		other := posB[r.Intn(len(posB))]
+		_, end, okA := clauseAt(a, start)
+		_, otherEnd, okB := clauseAt(b, other)
+		if okA && okB && r.Intn(2) == 0 {
+			res := append(append([]Token(nil), a[:start]...), b[other:otherEnd]...)
+			return append(res, a[end:]...), true
+		}
+		return append(append([]Token(nil), a[:start]...), b[other:]...), true
+	}
+	return nil, false
+}
+
+func keywordPositions(tokens []Token, words []string) []int {
+	var res []int
+	for i, t := range tokens {
+		if t.isKeyword(words...) {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// spliceTokens joins a before a random token with b from a random token
+func spliceTokens(r *rand.Rand, a, b []Token) ([]Token, bool) {
+	sigA, sigB := significantPositions(a), significantPositions(b)
+	if len(sigA) < 2 || len(sigB) < 2 {
+		return nil, false
+	}
+	// at least a token of each
+	i := sigA[1+r.Intn(len(sigA)-1)]
+	j := sigB[1+r.Intn(len(sigB)-1)]
+	res := append(append([]Token(nil), a[:i]...), Token{Kind: SpaceToken, Text: " "})
+	return append(res, b[j:]...), true
+}
+
+func significantPositions(tokens []Token) []int {
+	var res []int
+	for i, t := range tokens {
+		if t.significant() {
+			res = append(res, i)
+		}
+	}
+	return res
+}
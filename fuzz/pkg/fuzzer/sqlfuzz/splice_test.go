@@ -0,0 +1,74 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpliceStatements(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	_, ok := spliceStatements(r, Tokenize("select 1"), Tokenize("select 2"))
+	assert.False(t, ok)
+
+	a := Tokenize("create table t (a int); insert into t values (1)")
+	b := Tokenize("select 2; select (select 3; ) ; select 4")
+	assert.Equal(t, []int{0, 4, 16}, statementStarts(b))
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		res, ok := spliceStatements(r, a, b)
+		assert.True(t, ok)
+		seen[Render(res)] = true
+	}
+	assert.True(t, seen["create table t (a int); select 4"])
+	assert.True(t, seen["create table t (a int); insert into t values (1); select 2; select (select 3; ) ; select 4"])
+	for sql := range seen {
+		assert.True(t, strings.HasPrefix(sql, "create table t (a int);"), sql)
+	}
+}
+
+func TestSpliceClauses(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a := Tokenize("select a from t where a > 1 order by a")
+	b := Tokenize("SELECT b FROM s JOIN u ON s.id = u.id WHERE b IS NULL")
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		res, ok := spliceClauses(r, a, b)
+		assert.True(t, ok)
+		seen[Render(res)] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"select a from t WHERE b IS NULL":                       true,
+		"select a from t WHERE b IS NULL order by a":            true,
+		"select a FROM s JOIN u ON s.id = u.id WHERE b IS NULL": true,
+	}, seen)
+
+	_, ok := spliceClauses(r, a, Tokenize("show tables"))
+	assert.False(t, ok)
+}
+
+func TestSpliceMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	corpus := NewCorpus()
+	m := NewSpliceMutator(corpus, &TextMutator{})
+
+	// falls back without other inputs
+	corpus.Add(&Input{SQL: "select a from t"})
+	assert.NotEqual(t, "select a from t", m.Mutate(r, "select a from t"))
+	for _, s := range m.Stats() {
+		assert.Zero(t, s.Uses)
+	}
+
+	corpus.Add(&Input{SQL: "select b from s where b = 1"})
+	for i := 0; i < 100; i++ {
+		sql := m.Mutate(r, "select a from t")
+		m.Feedback(sql, true)
+	}
+	uses := uint64(0)
+	for _, s := range m.Stats() {
+		uses += s.Uses
+	}
+	assert.Equal(t, uint64(100), uses)
+}
@@ -1,14 +1,599 @@
-// test
-
 package sqlfuzz
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dtypes "github.com/Illyrix/tidb-go-fuzz/dep/types"
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/tracer"
 	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/types"
+	"github.com/go-sql-driver/mysql"
 )
 
+// how long to wait before reconnecting to a crashed tidb-server
+const reconnectInterval = time.Second
+
+// how often coverage and stats are saved into the campaign dir
+const syncInterval = 30 * time.Second
+
+// SQLFuzzer executes inputs in the instrumented tidb-server through the
+// MySQL protocol, keeps the ones hitting new coverage and mutates them
 type SQLFuzzer struct {
 	types.Fuzzer
 
-	sql  string
-	conn db.Conn // db connection
+	config   *types.FuzzerConfig
+	db       *sql.DB
+	conn     *sql.Conn // one connection, so statements of an input share the session
+	tracer   *tracer.Client
+	corpus   *Corpus
+	coverage *dtypes.Coverage
+	mutator  Mutator
+	havoc    *HavocMutator
+	splice   *SpliceMutator
+	bandit   *BanditMutator
+	schedule *Scheduler
+	parent   *Input // the input being mutated; nil for seeds
+	rand     *rand.Rand
+	stats    Stats
+	start    time.Time
+
+	campaign     *Campaign        // nil if not saving the run
+	hangCoverage *dtypes.Coverage // timed out inputs are saved only if they hit new edges of it
+	uniquePanics map[string]bool  // site and value of panics saved
+	crashedPaths map[uint64]bool  // paths of inputs whose mutations crashed tidb-server
+	lastSync     time.Time
+}
+
+type Stats struct {
+	Execs    uint64
+	Errors   uint64 // inputs failed with SQL errors, which is common
+	Timeouts uint64
+	Crashes  uint64        // tidb-server is gone after executing an input
+	Panics   uint64        // panics recovered by tidb
+	RunTime  time.Duration // including previous runs of a resumed campaign
+
+	Havoc  []OperatorStats // of every operation of the havoc stage
+	Splice []OperatorStats // of every operation of the splice stage
+	Bandit []OperatorStats // of every mutator, halved as the bandit forgets old yields
+}
+
+// Result of executing an input
+type Result struct {
+	Err        error // returned by tidb
+	NewEdges   int
+	NewBuckets int
+	Edges      []int  // indexes of edges hit
+	Path       uint64 // hash of Edges
+	ExecTime   time.Duration
+	Timeout    bool
+	UniqueHang bool // timed out with edges never hit by other timed out inputs
+	Crashed    bool
+	Panics     []dtypes.PanicRecord
+}
+
+func NewSQLFuzzer(ctx context.Context, config *types.FuzzerConfig) (*SQLFuzzer, error) {
+	if err := config.Valid(); err != nil {
+		return nil, err
+	}
+	dsn, err := mysql.ParseDSN(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	// an input may have more than one statement
+	dsn.MultiStatements = true
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	schedule, err := ParseSchedule(config.Schedule)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	f := &SQLFuzzer{
+		Fuzzer:       types.Fuzzer{Ctx: ctx},
+		config:       config,
+		db:           db,
+		tracer:       tracer.NewClient(config.TraceAddr),
+		corpus:       NewCorpus(),
+		coverage:     dtypes.NewCoverage(),
+		rand:         rand.New(rand.NewSource(config.RandSeed)),
+		hangCoverage: dtypes.NewCoverage(),
+		uniquePanics: make(map[string]bool),
+		crashedPaths: make(map[uint64]bool),
+	}
+	f.schedule = NewScheduler(schedule, f.corpus)
+	text := NewTextMutator(config.Dict)
+	f.havoc = NewHavocMutator(config.Dict)
+	f.splice = NewSpliceMutator(f.corpus, text)
+	arms := []Arm{
+		{"token", NewTokenMutator(text)},
+		{"text", text},
+		{"havoc", f.havoc},
+		{"splice", f.splice},
+	}
+	if config.Grammar != "" {
+		g, err := loadGrammar(config.Grammar, config.GrammarStart)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		arms = append(arms, Arm{"grammar", g})
+	}
+	f.bandit = NewBanditMutator(arms...)
+	f.mutator = f.bandit
+	if config.CampaignDir != "" {
+		if f.campaign, err = OpenCampaign(config.CampaignDir, config.Resume); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func loadGrammar(path, start string) (*GrammarMutator, error) {
+	path, err := FindGrammar(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := LoadGrammar(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewGrammarMutator(g, start)
+}
+
+func (f *SQLFuzzer) Corpus() *Corpus {
+	return f.corpus
+}
+
+func (f *SQLFuzzer) Coverage() *dtypes.Coverage {
+	return f.coverage
+}
+
+func (f *SQLFuzzer) Stats() Stats {
+	return Stats{
+		Execs:    atomic.LoadUint64(&f.stats.Execs),
+		Errors:   atomic.LoadUint64(&f.stats.Errors),
+		Timeouts: atomic.LoadUint64(&f.stats.Timeouts),
+		Crashes:  atomic.LoadUint64(&f.stats.Crashes),
+		Panics:   atomic.LoadUint64(&f.stats.Panics),
+		RunTime:  f.stats.RunTime + time.Since(f.start),
+		Havoc:    f.havoc.Stats(),
+		Splice:   f.splice.Stats(),
+		Bandit:   f.bandit.Stats(),
+	}
+}
+
+// Run executes the seeds, then mutates inputs in corpus until Ctx is done;
+// a resumed campaign reloads its queue before the seeds
+func (f *SQLFuzzer) Run() error {
+	defer f.close()
+	f.start = time.Now()
+
+	if f.campaign != nil {
+		if f.config.Resume {
+			if err := f.resume(); err != nil {
+				return err
+			}
+		}
+		if err := f.campaign.SaveConfig(f.config); err != nil {
+			return err
+		}
+		f.lastSync = time.Now()
+	}
+	if f.config.StatsInterval > 0 {
+		go f.printStats()
+	}
+
+	for _, seed := range f.config.Seeds {
+		if _, _, err := f.fuzzUntilDone(seed); err != nil {
+			return err
+		}
+	}
+	for f.Ctx.Err() == nil {
+		// mutations of an empty input are generated ones
+		var sql string
+		input, energy := f.schedule.Next(f.rand)
+		if input != nil {
+			sql = input.SQL
+		} else {
+			energy = 1
+		}
+		f.parent = input
+		for i := 0; i < energy && f.Ctx.Err() == nil; i++ {
+			if err := f.fuzzMutation(sql); err != nil {
+				return err
+			}
+		}
+		f.parent = nil
+	}
+	if f.campaign != nil {
+		return f.sync()
+	}
+	return nil
+}
+
+// resume reloads stats, coverage, known panics and hangs and queue of the
+// campaign, every entry in queue is executed again to check it against the
+// current build
+func (f *SQLFuzzer) resume() error {
+	stats, err := f.campaign.LoadStats()
+	if err != nil {
+		return err
+	}
+	f.stats = stats
+	f.havoc.Restore(stats.Havoc)
+	f.splice.Restore(stats.Splice)
+	f.bandit.Restore(stats.Bandit)
+
+	dump, err := f.campaign.LoadCoverage()
+	if err != nil {
+		return err
+	}
+	// edges of another build are meaningless, the map is rebuilt from queue
+	restored := dump != nil && f.config.BuildID != "" && dump.BuildID == f.config.BuildID
+	if restored {
+		if err := f.coverage.Restore(dump.Bits); err != nil {
+			return err
+		}
+	} else if dump != nil {
+		log.Printf("coverage of build %q mismatches build %q, rebuild it from queue", dump.BuildID, f.config.BuildID)
+	}
+	if restored {
+		hangs, err := f.campaign.LoadHangCoverage()
+		if err != nil {
+			return err
+		}
+		if hangs != nil && hangs.BuildID == f.config.BuildID {
+			if err := f.hangCoverage.Restore(hangs.Bits); err != nil {
+				return err
+			}
+		}
+	}
+	reports, err := f.campaign.LoadCrashReports()
+	if err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if key, ok := parsePanicReport(report); ok {
+			f.uniquePanics[key] = true
+		}
+	}
+
+	queue, err := f.campaign.LoadQueue()
+	if err != nil {
+		return err
+	}
+	for _, entry := range queue {
+		res := f.execUntilDone(entry.SQL)
+		if res == nil {
+			return nil
+		}
+		switch {
+		case res.Crashed || res.Timeout:
+			log.Printf("drop %s: it crashes or times out in this build", entry.File)
+		case restored || res.NewEdges > 0 || res.NewBuckets > 0:
+			f.addInput(&Input{
+				SQL:        entry.SQL,
+				NewEdges:   res.NewEdges,
+				NewBuckets: res.NewBuckets,
+				ExecTime:   res.ExecTime,
+				Found:      time.Now(),
+				File:       entry.File,
+				Edges:      res.Edges,
+				Path:       res.Path,
+				Depth:      1,
+			})
+		default:
+			log.Printf("drop %s: it hits no new coverage in this build", entry.File)
+		}
+	}
+	log.Printf("resumed %d of %d inputs in queue, %d edges", f.corpus.Len(), len(queue), f.coverage.EverHit())
+	return nil
+}
+
+// sync saves coverage and stats into the campaign dir
+func (f *SQLFuzzer) sync() error {
+	f.lastSync = time.Now()
+	if err := f.campaign.SaveCoverage(f.coverage, f.config.BuildID); err != nil {
+		return err
+	}
+	if err := f.campaign.SaveHangCoverage(f.hangCoverage, f.config.BuildID); err != nil {
+		return err
+	}
+	return f.campaign.SaveStats(f.Stats(), f.corpus.Len(), f.coverage.EverHit())
+}
+
+// retry until tidb-server is back, e.g. restarted by a supervisor after a
+// crash; it returns nil if Ctx is done
+func (f *SQLFuzzer) execUntilDone(sql string) *Result {
+	for {
+		res, err := f.Exec(sql)
+		if err == nil {
+			return res
+		}
+		if f.Ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("exec error: %v, retry in %s", err, reconnectInterval)
+		select {
+		case <-f.Ctx.Done():
+			return nil
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// fuzzMutation executes a mutation of sql, and gives feedback to mutators;
+// only new coverage, crashes and panics are rewarded, not known ones
+func (f *SQLFuzzer) fuzzMutation(sql string) error {
+	mutated := f.mutator.Mutate(f.rand, sql)
+	res, found, err := f.fuzzUntilDone(mutated)
+	if err != nil {
+		return err
+	}
+	if fb, ok := f.mutator.(Feedback); ok && res != nil {
+		fb.Feedback(mutated, found)
+	}
+	if f.campaign != nil && time.Since(f.lastSync) > syncInterval {
+		return f.sync()
+	}
+	return nil
+}
+
+func (f *SQLFuzzer) fuzzUntilDone(sql string) (*Result, bool, error) {
+	res := f.execUntilDone(sql)
+	if res == nil {
+		return nil, false, nil
+	}
+	found, err := f.save(sql, res)
+	return res, found, err
+}
+
+// Fuzz executes sql and keeps it in corpus if it hits new coverage; the
+// error is not nil if sql is not executed or it can't be saved
+func (f *SQLFuzzer) Fuzz(sql string) (*Result, error) {
+	res, err := f.Exec(sql)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.save(sql, res)
+	return res, err
+}
+
+// save keeps interesting inputs in corpus, and in the campaign dir if any;
+// it returns true if sql hits new coverage, or crashes or panics in a new
+// way. Crashes leave no trace, so the first crash of mutations of an input
+// is taken as new
+func (f *SQLFuzzer) save(sql string, res *Result) (bool, error) {
+	found := false
+	if res.NewEdges > 0 || res.NewBuckets > 0 {
+		found = true
+		input := &Input{
+			SQL:        sql,
+			NewEdges:   res.NewEdges,
+			NewBuckets: res.NewBuckets,
+			ExecTime:   res.ExecTime,
+			Found:      time.Now(),
+			Edges:      res.Edges,
+			Path:       res.Path,
+			Depth:      1,
+		}
+		if f.parent != nil {
+			input.Depth = f.parent.Depth + 1
+		}
+		if f.campaign != nil {
+			file, err := f.campaign.AddQueue(sql)
+			if err != nil {
+				return false, err
+			}
+			input.File = file
+		}
+		f.addInput(input)
+	}
+
+	if res.Crashed {
+		log.Printf("crash: tidb-server is gone after executing:\n%s", sql)
+		// only the first crash mutated from every path is kept, like panics;
+		// seeds and generated inputs share path 0
+		var path uint64
+		if f.parent != nil {
+			path = f.parent.Path
+		}
+		if !f.crashedPaths[path] {
+			f.crashedPaths[path] = true
+			found = true
+			if f.campaign != nil {
+				if _, err := f.campaign.AddCrash(sql, "tidb-server is gone\n"); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+	if res.UniqueHang && f.campaign != nil {
+		if _, err := f.campaign.AddHang(sql); err != nil {
+			return false, err
+		}
+	}
+	for _, p := range res.Panics {
+		key := panicKey(p.Id, p.Value)
+		if f.uniquePanics[key] {
+			continue
+		}
+		f.uniquePanics[key] = true
+		found = true
+		log.Printf("recovered panic at site %d: %s\nstatement:\n%s\n%s", p.Id, p.Value, sql, p.Stack)
+		if f.campaign != nil {
+			report := fmt.Sprintf("%s%d: %s\n%s", panicReportPrefix, p.Id, p.Value, p.Stack)
+			if _, err := f.campaign.AddCrash(sql, report); err != nil {
+				return false, err
+			}
+		}
+	}
+	return found, nil
+}
+
+// reports of panics in crashes start with it, followed by `id: value`
+const panicReportPrefix = "panic recovered at site "
+
+func panicKey(id dtypes.BlockIdType, value string) string {
+	return fmt.Sprintf("%d %s", id, value)
+}
+
+// parsePanicReport returns the key of the panic in report, reports of
+// crashes are not panics; values of more than one line are not restored
+func parsePanicReport(report string) (string, bool) {
+	if !strings.HasPrefix(report, panicReportPrefix) {
+		return "", false
+	}
+	line := strings.SplitN(strings.TrimPrefix(report, panicReportPrefix), "\n", 2)[0]
+	kv := strings.SplitN(line, ": ", 2)
+	if len(kv) != 2 {
+		return "", false
+	}
+	id, err := strconv.ParseUint(kv[0], 10, 16)
+	if err != nil {
+		return "", false
+	}
+	return panicKey(dtypes.BlockIdType(id), kv[1]), true
+}
+
+func (f *SQLFuzzer) addInput(input *Input) {
+	f.corpus.Add(input)
+	f.schedule.Add(input)
+	if l, ok := f.mutator.(Learner); ok {
+		l.Learn(input.SQL)
+	}
+}
+
+// Exec executes sql in a clean trace table, and merges the coverage of it
+func (f *SQLFuzzer) Exec(sql string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(f.Ctx, f.config.Timeout)
+	defer cancel()
+
+	if err := f.connect(ctx); err != nil {
+		return nil, err
+	}
+	if f.config.Prepare != "" {
+		if err := f.query(ctx, f.config.Prepare); err != nil {
+			f.closeConn()
+			return nil, fmt.Errorf("prepare: %v", err)
+		}
+	}
+	// drop the counts of prepare statements and background jobs
+	if _, err := f.tracer.FetchRawBits(); err != nil {
+		return nil, err
+	}
+	if err := f.tracer.SetStatement(sql); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res := &Result{Err: f.query(ctx, sql)}
+	res.ExecTime = time.Since(start)
+	atomic.AddUint64(&f.stats.Execs, 1)
+
+	bits, err := f.tracer.FetchRawBits()
+	if err != nil {
+		// the trace server is gone with tidb-server
+		res.Crashed = true
+		atomic.AddUint64(&f.stats.Crashes, 1)
+		f.closeConn()
+		return res, nil
+	}
+	res.NewEdges, res.NewBuckets, _ = f.coverage.MergeBuckets(bits)
+	res.Edges, res.Path = edgesOf(bits.GetBits())
+	f.schedule.Executed(res.Path)
+	if f.config.Panics {
+		if res.Panics, _, err = f.tracer.FetchPanics(); err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&f.stats.Panics, uint64(len(res.Panics)))
+	}
+
+	if res.Err != nil {
+		atomic.AddUint64(&f.stats.Errors, 1)
+		if ctx.Err() == context.DeadlineExceeded {
+			res.Timeout = true
+			atomic.AddUint64(&f.stats.Timeouts, 1)
+			newEdges, _ := f.hangCoverage.Merge(bits)
+			res.UniqueHang = newEdges > 0
+		}
+		// the connection may be broken or killed
+		if res.Timeout || isConnError(res.Err) {
+			f.closeConn()
+		}
+	}
+	return res, nil
+}
+
+// query reads all rows of all statements, since tidb executes lazily
+func (f *SQLFuzzer) query(ctx context.Context, sql string) error {
+	rows, err := f.conn.QueryContext(ctx, sql)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for {
+		for rows.Next() {
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (f *SQLFuzzer) connect(ctx context.Context) error {
+	if f.conn != nil {
+		return nil
+	}
+	conn, err := f.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *SQLFuzzer) closeConn() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func (f *SQLFuzzer) close() {
+	f.closeConn()
+	f.db.Close()
+}
+
+func isConnError(err error) bool {
+	return err == mysql.ErrInvalidConn || err == sql.ErrConnDone || err.Error() == "driver: bad connection"
+}
+
+func (f *SQLFuzzer) printStats() {
+	ticker := time.NewTicker(f.config.StatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.Ctx.Done():
+			return
+		case <-ticker.C:
+			s := f.Stats()
+			log.Printf("run time %s, execs %d (%.1f/s), corpus %d (%d favored), edges %d, errors %d, timeouts %d, crashes %d, panics %d",
+				s.RunTime.Truncate(time.Second), s.Execs, float64(s.Execs)/s.RunTime.Seconds(), f.corpus.Len(),
+				f.schedule.Favored(), f.coverage.EverHit(), s.Errors, s.Timeouts, s.Crashes, s.Panics)
+		}
+	}
 }
@@ -0,0 +1,66 @@
+package sqlfuzz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtypes "github.com/Illyrix/tidb-go-fuzz/dep/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFuzzer() *SQLFuzzer {
+	f := &SQLFuzzer{
+		corpus:       NewCorpus(),
+		uniquePanics: make(map[string]bool),
+		crashedPaths: make(map[uint64]bool),
+	}
+	f.schedule = NewScheduler(FastSchedule, f.corpus)
+	return f
+}
+
+func TestSaveFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "campaign")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	f := newTestFuzzer()
+	f.campaign, err = OpenCampaign(dir, false)
+	assert.NoError(t, err)
+
+	found, err := f.save("select 1", &Result{NewEdges: 1, Edges: []int{1}, Path: 7})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, f.corpus.Len())
+	found, _ = f.save("select 1", &Result{})
+	assert.False(t, found)
+
+	// known panics and crashes of the same input are not new
+	panics := []dtypes.PanicRecord{{Id: 1, Value: "index out of range"}}
+	found, _ = f.save("select 2", &Result{Panics: panics})
+	assert.True(t, found)
+	found, _ = f.save("select 3", &Result{Panics: panics})
+	assert.False(t, found)
+
+	f.parent = f.corpus.Inputs()[0]
+	found, _ = f.save("select 4", &Result{Crashed: true})
+	assert.True(t, found)
+	found, _ = f.save("select 5", &Result{Crashed: true})
+	assert.False(t, found)
+	f.parent = nil
+	found, _ = f.save("select 6", &Result{Crashed: true})
+	assert.True(t, found)
+
+	// only unique panics and crashes are saved
+	crashes, err := filepath.Glob(filepath.Join(dir, CrashesDir, "*.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(crashes))
+}
+
+func TestParsePanicReport(t *testing.T) {
+	key, ok := parsePanicReport("panic recovered at site 12: runtime error: index out of range\ngoroutine 1 [running]:\n")
+	assert.True(t, ok)
+	assert.Equal(t, panicKey(12, "runtime error: index out of range"), key)
+	_, ok = parsePanicReport("tidb-server is gone\n")
+	assert.False(t, ok)
+}
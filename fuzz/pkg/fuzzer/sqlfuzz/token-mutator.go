@@ -0,0 +1,348 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// at most this many schema names are learned
+const maxNames = 4096
+
+// how many times to look for an applicable mutation before falling back
+const maxTokenTries = 8
+
+// operators replaced by others of the same class
+var operatorClasses = [][]string{
+	{"=", "<>", "!=", "<", "<=", ">", ">=", "<=>"},
+	{"+", "-", "*", "/", "%", "DIV", "MOD"},
+	{"&", "|", "^", "<<", ">>"},
+	{"AND", "OR", "XOR", "&&", "||"},
+	{"LIKE", "REGEXP", "RLIKE"},
+}
+
+var interestingStrings = []string{
+	"", " ", "0", "-1", "a", "A", "%", "_", "\\", "NULL", "é", "\U0001F600",
+	strings.Repeat("a", 256), "{}", "[]", `{"a": [1, null]}`, "0x41", "1e308",
+}
+
+var interestingDates = []string{
+	"0000-00-00", "0000-00-00 00:00:00", "1000-01-01", "1970-01-01 00:00:01", "2000-02-29",
+	"2038-01-19 03:14:07", "9999-12-31", "9999-12-31 23:59:59.999999", "2020-13-32",
+	"00:00:00", "838:59:59", "-838:59:59", "23:59:59.999999",
+}
+
+var dateLit = regexp.MustCompile(`^-?\d{1,4}[-:]\d{1,2}`)
+
+// clauses started by these keywords may be dropped; UNION and JOIN
+// clauses may also be duplicated
+var clauseKeywords = []string{
+	"WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "EXCEPT", "INTERSECT", "JOIN",
+	"LEFT", "RIGHT", "INNER", "CROSS", "NATURAL", "STRAIGHT_JOIN", "ON", "USING", "WINDOW",
+	"PARTITION", "FOR", "LOCK",
+}
+
+// TokenMutator mutates statements token by token, so results are still
+// lexically valid and more of them reach the planner than TextMutator's
+type TokenMutator struct {
+	fallback Mutator // for inputs without applicable mutations
+	names    *schemaNames
+}
+
+func NewTokenMutator(fallback Mutator) *TokenMutator {
+	return &TokenMutator{fallback: fallback, names: newSchemaNames()}
+}
+
+// Learn records identifiers of sql as known schema names
+func (m *TokenMutator) Learn(sql string) {
+	m.names.learn(sql)
+}
+
+// schemaNames are identifiers in inputs of corpus, like tables and columns
+type schemaNames struct {
+	names []string
+	set   map[string]bool
+	mu    sync.RWMutex
+}
+
+func newSchemaNames() *schemaNames {
+	return &schemaNames{set: make(map[string]bool)}
+}
+
+func (n *schemaNames) learn(sql string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, t := range Tokenize(sql) {
+		if len(n.names) >= maxNames {
+			return
+		}
+		var name string
+		switch t.Kind {
+		case IdentToken:
+			name = t.Text
+		case QuotedIdentToken:
+			name = unquoteIdent(t.Text)
+		default:
+			continue
+		}
+		if name != "" && !n.set[name] {
+			n.set[name] = true
+			n.names = append(n.names, name)
+		}
+	}
+}
+
+func (n *schemaNames) random(r *rand.Rand) (string, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if len(n.names) == 0 {
+		return "", false
+	}
+	return n.names[r.Intn(len(n.names))], true
+}
+
+func (m *TokenMutator) Mutate(r *rand.Rand, sql string) string {
+	tokens := Tokenize(sql)
+	var significant []int
+	for i, t := range tokens {
+		if t.significant() {
+			significant = append(significant, i)
+		}
+	}
+	if len(significant) > 0 {
+		mutations := []func(*rand.Rand, []Token, int) ([]Token, bool){
+			m.replaceIdent, swapOperator, mutateLiteral, dropClause, duplicateClause, requote,
+		}
+		for try := 0; try < maxTokenTries; try++ {
+			pos := significant[r.Intn(len(significant))]
+			mutate := mutations[r.Intn(len(mutations))]
+			if res, ok := mutate(r, tokens, pos); ok {
+				return Render(res)
+			}
+		}
+	}
+	return m.fallback.Mutate(r, sql)
+}
+
+// replaceIdent replaces an identifier with a known name, keeping it quoted
+// if it was
+func (m *TokenMutator) replaceIdent(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	t := tokens[pos]
+	if t.Kind != IdentToken && t.Kind != QuotedIdentToken {
+		return nil, false
+	}
+	name, ok := m.names.random(r)
+	if !ok {
+		return nil, false
+	}
+	if t.Kind == QuotedIdentToken || !isBareIdent(name) {
+		return replaceToken(tokens, pos, Token{Kind: QuotedIdentToken, Text: quoteIdent(name)}), true
+	}
+	return replaceToken(tokens, pos, Token{Kind: IdentToken, Text: name}), true
+}
+
+func swapOperator(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	t := tokens[pos]
+	if t.Kind != OperatorToken && t.Kind != KeywordToken {
+		return nil, false
+	}
+	for _, class := range operatorClasses {
+		for _, op := range class {
+			if !strings.EqualFold(op, t.Text) {
+				continue
+			}
+			res := class[r.Intn(len(class))]
+			kind := OperatorToken
+			if isIdentChar(res[0]) {
+				kind = KeywordToken
+			}
+			return replaceToken(tokens, pos, Token{Kind: kind, Text: res}), true
+		}
+	}
+	return nil, false
+}
+
+// mutateLiteral moves numbers, strings and dates toward boundary values
+func mutateLiteral(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	t := tokens[pos]
+	switch t.Kind {
+	case NumberToken:
+		res := interestingNumbers[r.Intn(len(interestingNumbers))]
+		if n, err := strconv.ParseInt(t.Text, 10, 64); err == nil && r.Intn(2) == 0 {
+			switch r.Intn(3) {
+			case 0:
+				res = strconv.FormatInt(n+1, 10)
+			case 1:
+				res = strconv.FormatInt(n-1, 10)
+			default:
+				res = strconv.FormatInt(-n, 10)
+			}
+		}
+		// `- -1` instead of `--1`, which may be a comment
+		if strings.HasPrefix(res, "-") && pos > 0 && strings.HasSuffix(tokens[pos-1].Text, "-") {
+			res = " " + res
+		}
+		return replaceToken(tokens, pos, Token{Kind: NumberToken, Text: res}), true
+	case StringToken:
+		quote := t.Text[0]
+		res := interestingStrings[r.Intn(len(interestingStrings))]
+		if dateLit.MatchString(unquoteString(t.Text)) || r.Intn(4) == 0 {
+			res = interestingDates[r.Intn(len(interestingDates))]
+		}
+		return replaceToken(tokens, pos, Token{Kind: StringToken, Text: quoteString(res, quote)}), true
+	}
+	return nil, false
+}
+
+// dropClause removes a clause like `WHERE ...` or an item of a list
+func dropClause(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	start, end, ok := clauseAt(tokens, pos)
+	if !ok {
+		// `a,` of `a, b`
+		if start, end, ok = itemAt(tokens, pos); !ok {
+			return nil, false
+		}
+		end++
+	}
+	res := append(append([]Token(nil), tokens[:start]...), tokens[end:]...)
+	return res, true
+}
+
+// duplicateClause repeats a UNION or JOIN clause, or an item of a list
+func duplicateClause(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	start, end, ok := clauseAt(tokens, pos)
+	if ok && tokens[start].isKeyword("UNION", "EXCEPT", "INTERSECT", "JOIN", "LEFT", "RIGHT", "INNER", "CROSS", "NATURAL") {
+		dup := append([]Token{{Kind: SpaceToken, Text: " "}}, tokens[start:end]...)
+		return insertTokens(tokens, end, dup), true
+	}
+	if start, end, ok = itemAt(tokens, pos); ok {
+		// `a, b` to `a, a, b`
+		dup := append(append([]Token(nil), tokens[start:end]...), tokens[end])
+		return insertTokens(tokens, start, dup), true
+	}
+	return nil, false
+}
+
+// requote quotes bare identifiers, and unquotes others if they're valid bare
+func requote(r *rand.Rand, tokens []Token, pos int) ([]Token, bool) {
+	t := tokens[pos]
+	switch t.Kind {
+	case IdentToken:
+		return replaceToken(tokens, pos, Token{Kind: QuotedIdentToken, Text: quoteIdent(t.Text)}), true
+	case QuotedIdentToken:
+		name := unquoteIdent(t.Text)
+		if !isBareIdent(name) {
+			return nil, false
+		}
+		return replaceToken(tokens, pos, Token{Kind: IdentToken, Text: name}), true
+	}
+	return nil, false
+}
+
+// clauseAt returns the range of the clause started by the keyword at pos,
+// it ends before the next clause or statement at the same depth
+func clauseAt(tokens []Token, pos int) (int, int, bool) {
+	if !tokens[pos].isKeyword(clauseKeywords...) {
+		return 0, 0, false
+	}
+	depth := 0
+	prev := tokens[pos]
+	for i := pos + 1; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.Text == "(":
+			depth++
+		case t.Text == ")":
+			if depth == 0 {
+				return pos, i, true
+			}
+			depth--
+		case depth == 0 && t.Text == ";":
+			return pos, i, true
+		// JOIN of `LEFT JOIN` or BY of `ORDER BY` doesn't start a new clause
+		case depth == 0 && t.isKeyword(clauseKeywords...) && !prev.isKeyword(clauseKeywords...) && !prev.isKeyword("OUTER"):
+			return pos, i, true
+		}
+		if t.significant() {
+			prev = t
+		}
+	}
+	return pos, len(tokens), true
+}
+
+// itemAt returns the range of the list item followed by the comma at pos,
+// the item starts after the previous comma, keyword or `(` at the same depth
+func itemAt(tokens []Token, pos int) (int, int, bool) {
+	if tokens[pos].Text != "," {
+		return 0, 0, false
+	}
+	depth := 0
+	for i := pos - 1; i >= 0; i-- {
+		t := tokens[i]
+		switch {
+		case t.Text == ")":
+			depth++
+		case t.Text == "(":
+			if depth == 0 {
+				return i + 1, pos, i+1 < pos
+			}
+			depth--
+		case depth == 0 && (t.Text == "," || t.Text == ";" || t.Kind == KeywordToken):
+			return i + 1, pos, i+1 < pos
+		}
+	}
+	return 0, pos, pos > 0
+}
+
+func replaceToken(tokens []Token, pos int, t Token) []Token {
+	res := append([]Token(nil), tokens...)
+	res[pos] = t
+	return res
+}
+
+func insertTokens(tokens []Token, pos int, inserted []Token) []Token {
+	res := make([]Token, 0, len(tokens)+len(inserted))
+	res = append(res, tokens[:pos]...)
+	res = append(res, inserted...)
+	return append(res, tokens[pos:]...)
+}
+
+func isBareIdent(name string) bool {
+	if name == "" || sqlKeywords[strings.ToUpper(name)] {
+		return false
+	}
+	allDigits := true
+	for i := 0; i < len(name); i++ {
+		if !isWordChar(name[i]) {
+			return false
+		}
+		allDigits = allDigits && isDigit(name[i])
+	}
+	return !allDigits
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func unquoteIdent(text string) string {
+	if len(text) < 2 || text[len(text)-1] != '`' {
+		return strings.TrimPrefix(text, "`")
+	}
+	return strings.ReplaceAll(text[1:len(text)-1], "``", "`")
+}
+
+func quoteString(value string, quote byte) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, string(quote), string([]byte{quote, quote}))
+	return string(quote) + value + string(quote)
+}
+
+func unquoteString(text string) string {
+	if len(text) < 2 {
+		return ""
+	}
+	return text[1 : len(text)-1]
+}
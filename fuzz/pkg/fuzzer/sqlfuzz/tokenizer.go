@@ -0,0 +1,254 @@
+package sqlfuzz
+
+import (
+	"strings"
+)
+
+type TokenKind int
+
+const (
+	SpaceToken TokenKind = iota
+	CommentToken
+	KeywordToken
+	IdentToken
+	QuotedIdentToken // like `a`
+	StringToken      // like 'a' or "a"
+	NumberToken
+	VariableToken // like @a or @@sql_mode
+	OperatorToken
+	PunctToken // ( ) , ; . and others
+)
+
+// Token of sql; concatenating Text of all tokens gives the sql back
+type Token struct {
+	Kind TokenKind
+	Text string
+}
+
+// significant tokens are not spaces or comments
+func (t Token) significant() bool {
+	return t.Kind != SpaceToken && t.Kind != CommentToken
+}
+
+func (t Token) isKeyword(words ...string) bool {
+	if t.Kind != KeywordToken {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.Text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlKeywords are reserved words of MySQL, which are not replaced as
+// identifiers; others like `DATE` are treated as identifiers
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		ADD ALL ALTER ANALYZE AND AS ASC BETWEEN BIGINT BINARY BLOB BOTH BY CASE CAST CHAR
+		CHARACTER CHECK COLLATE COLUMN CONSTRAINT CONVERT CREATE CROSS CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER DATABASE DATABASES DECIMAL DEFAULT DELAYED
+		DELETE DESC DESCRIBE DISTINCT DISTINCTROW DIV DOUBLE DROP DUAL ELSE ENCLOSED ESCAPED
+		EXCEPT EXISTS EXPLAIN FALSE FLOAT FOR FORCE FOREIGN FROM FULLTEXT GENERATED GRANT GROUP
+		HAVING HIGH_PRIORITY IF IGNORE IN INDEX INFILE INNER INSERT INT INTEGER INTERSECT
+		INTERVAL INTO IS JOIN KEY KEYS KILL LEADING LEFT LIKE LIMIT LINES LOAD LOCK LONGTEXT
+		LOW_PRIORITY MATCH MOD NATURAL NOT NULL NUMERIC ON OPTIMIZE OPTION OR ORDER OUTER
+		OVER PARTITION PRIMARY PROCEDURE RANGE READ REAL REFERENCES REGEXP RENAME REPLACE
+		RESTRICT REVOKE RIGHT RLIKE ROW ROWS SELECT SET SHOW SMALLINT SQL_CALC_FOUND_ROWS
+		STRAIGHT_JOIN TABLE TERMINATED THEN TINYINT TO TRAILING TRUE UNION UNIQUE UNLOCK
+		UNSIGNED UPDATE USE USING VALUES VARCHAR VARBINARY WHEN WHERE WINDOW WITH WRITE XOR
+		ZEROFILL`) {
+		sqlKeywords[w] = true
+	}
+}
+
+// multi-char operators, the longer first
+var operators = []string{"<=>", "->>", "<<", ">>", "<=", ">=", "<>", "!=", "||", "&&", ":=", "->"}
+
+// Tokenize splits sql into tokens like the lexer of MySQL, but it never
+// fails: unterminated strings and comments take the rest of sql
+func Tokenize(sql string) []Token {
+	var res []Token
+	for i := 0; i < len(sql); {
+		kind, end := scanToken(sql, i)
+		res = append(res, Token{Kind: kind, Text: sql[i:end]})
+		i = end
+	}
+	return res
+}
+
+func scanToken(sql string, i int) (TokenKind, int) {
+	c := sql[i]
+	switch {
+	case isSpace(c):
+		end := i + 1
+		for end < len(sql) && isSpace(sql[end]) {
+			end++
+		}
+		return SpaceToken, end
+	case c == '#' || strings.HasPrefix(sql[i:], "-- ") || strings.HasPrefix(sql[i:], "--\t") || sql[i:] == "--":
+		return CommentToken, lineEnd(sql, i)
+	case strings.HasPrefix(sql[i:], "/*"):
+		if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+			return CommentToken, i + 2 + end + 2
+		}
+		return CommentToken, len(sql)
+	case c == '\'' || c == '"':
+		return StringToken, quoteEnd(sql, i)
+	case c == '`':
+		return QuotedIdentToken, quoteEnd(sql, i)
+	case c == '@':
+		end := i + 1
+		if end < len(sql) && sql[end] == '@' {
+			end++
+		}
+		if end < len(sql) && (sql[end] == '\'' || sql[end] == '"' || sql[end] == '`') {
+			return VariableToken, quoteEnd(sql, end)
+		}
+		for end < len(sql) && (isWordChar(sql[end]) || sql[end] == '.') {
+			end++
+		}
+		return VariableToken, end
+	case isDigit(c) || c == '.' && i+1 < len(sql) && isDigit(sql[i+1]):
+		end := numberEnd(sql, i)
+		// like `1a` is an identifier in MySQL
+		if end < len(sql) && isWordChar(sql[end]) {
+			return IdentToken, wordEnd(sql, end)
+		}
+		return NumberToken, end
+	case isWordChar(c):
+		end := wordEnd(sql, i)
+		if sqlKeywords[strings.ToUpper(sql[i:end])] {
+			return KeywordToken, end
+		}
+		return IdentToken, end
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(sql[i:], op) {
+			return OperatorToken, i + len(op)
+		}
+	}
+	if strings.IndexByte("=<>!+-*/%&|^~", c) >= 0 {
+		return OperatorToken, i + 1
+	}
+	return PunctToken, i + 1
+}
+
+func lineEnd(sql string, i int) int {
+	if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+		return i + end
+	}
+	return len(sql)
+}
+
+// quoteEnd returns the end of a quoted token starting at i; the quote is
+// escaped by doubling it, or by backslash except in identifiers
+func quoteEnd(sql string, i int) int {
+	quote := sql[i]
+	for end := i + 1; end < len(sql); end++ {
+		switch sql[end] {
+		case '\\':
+			if quote != '`' {
+				end++
+			}
+		case quote:
+			if end+1 < len(sql) && sql[end+1] == quote {
+				end++
+				continue
+			}
+			return end + 1
+		}
+	}
+	return len(sql)
+}
+
+// numberEnd scans integers, decimals, floats like 1.5e-3, and 0x or 0b literals
+func numberEnd(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0b") {
+		end := i + 2
+		for end < len(sql) && isHexDigit(sql[end]) {
+			end++
+		}
+		if end > i+2 {
+			return end
+		}
+	}
+	end := i
+	for end < len(sql) && isDigit(sql[end]) {
+		end++
+	}
+	if end < len(sql) && sql[end] == '.' {
+		end++
+		for end < len(sql) && isDigit(sql[end]) {
+			end++
+		}
+	}
+	if end < len(sql) && (sql[end] == 'e' || sql[end] == 'E') {
+		exp := end + 1
+		if exp < len(sql) && (sql[exp] == '+' || sql[exp] == '-') {
+			exp++
+		}
+		if exp < len(sql) && isDigit(sql[exp]) {
+			end = exp
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+		}
+	}
+	return end
+}
+
+func wordEnd(sql string, i int) int {
+	for i < len(sql) && isWordChar(sql[i]) {
+		i++
+	}
+	return i
+}
+
+// Render joins tokens, with spaces between tokens which would be merged,
+// like two words, or `-` and `-` which may start a comment
+func Render(tokens []Token) string {
+	var sb strings.Builder
+	for i, t := range tokens {
+		if i > 0 && needSpace(tokens[i-1], t) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t.Text)
+	}
+	return sb.String()
+}
+
+func needSpace(prev, next Token) bool {
+	if isWordToken(prev) && isWordToken(next) {
+		return true
+	}
+	if prev.Kind != OperatorToken || next.Kind != OperatorToken {
+		return false
+	}
+	last, first := prev.Text[len(prev.Text)-1], next.Text[0]
+	return last == '-' && first == '-' || last == '/' && first == '*' || last == '*' && first == '/'
+}
+
+func isWordToken(t Token) bool {
+	switch t.Kind {
+	case KeywordToken, IdentToken, NumberToken, VariableToken:
+		return true
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// isWordChar is like isIdentChar, also allowing non-ascii bytes of utf8
+func isWordChar(c byte) bool {
+	return isIdentChar(c) || c >= 0x80
+}
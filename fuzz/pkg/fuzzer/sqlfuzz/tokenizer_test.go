@@ -0,0 +1,115 @@
+package sqlfuzz
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	sql := "SELECT `a``b`, 'it''s\\'', 1.5e-3, 0x1F, 1a, @@sql_mode, @v /* hint */ FROM t -- comment\n" +
+		"WHERE a<=>b AND c != \"x\" # comment\n;select .5"
+	tokens := Tokenize(sql)
+	assert.Equal(t, sql, Render(tokens))
+
+	var kinds []TokenKind
+	var texts []string
+	for _, token := range tokens {
+		if token.Kind != SpaceToken {
+			kinds = append(kinds, token.Kind)
+			texts = append(texts, token.Text)
+		}
+	}
+	assert.Equal(t, []string{
+		"SELECT", "`a``b`", ",", "'it''s\\''", ",", "1.5e-3", ",", "0x1F", ",", "1a", ",", "@@sql_mode", ",", "@v",
+		"/* hint */", "FROM", "t", "-- comment", "WHERE", "a", "<=>", "b", "AND", "c", "!=", "\"x\"", "# comment",
+		";", "select", ".5",
+	}, texts)
+	assert.Equal(t, []TokenKind{
+		KeywordToken, QuotedIdentToken, PunctToken, StringToken, PunctToken, NumberToken, PunctToken, NumberToken,
+		PunctToken, IdentToken, PunctToken, VariableToken, PunctToken, VariableToken, CommentToken, KeywordToken,
+		IdentToken, CommentToken, KeywordToken, IdentToken, OperatorToken, IdentToken, KeywordToken, IdentToken,
+		OperatorToken, StringToken, CommentToken, PunctToken, KeywordToken, NumberToken,
+	}, kinds)
+
+	// never fails on unterminated tokens
+	assert.Equal(t, []Token{{KeywordToken, "select"}, {SpaceToken, " "}, {StringToken, "'abc"}}, Tokenize("select 'abc"))
+	assert.Equal(t, "a- -1", Render([]Token{{IdentToken, "a"}, {OperatorToken, "-"}, {OperatorToken, "-"}, {NumberToken, "1"}}))
+	assert.Equal(t, "select a", Render([]Token{{KeywordToken, "select"}, {IdentToken, "a"}}))
+}
+
+func TestTokenMutations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tokens := Tokenize("select a, b + 1 from t left join s on t.a = s.a where a > 'x' order by b")
+	find := func(text string) int {
+		for i, token := range tokens {
+			if token.Text == text {
+				return i
+			}
+		}
+		t.Fatalf("no token %s", text)
+		return 0
+	}
+
+	res, ok := dropClause(r, tokens, find("where"))
+	assert.True(t, ok)
+	assert.Equal(t, "select a, b + 1 from t left join s on t.a = s.a order by b", Render(res))
+	res, ok = dropClause(r, tokens, find(","))
+	assert.True(t, ok)
+	assert.Equal(t, "select b + 1 from t left join s on t.a = s.a where a > 'x' order by b", strings.TrimSpace(Render(res)))
+	_, ok = dropClause(r, tokens, find("from"))
+	assert.False(t, ok)
+
+	res, ok = duplicateClause(r, tokens, find("left"))
+	assert.True(t, ok)
+	assert.Equal(t, "select a, b + 1 from t left join s  left join s on t.a = s.a where a > 'x' order by b", Render(res))
+	res, ok = duplicateClause(r, tokens, find(","))
+	assert.True(t, ok)
+	assert.Equal(t, "select a, a, b + 1 from t left join s on t.a = s.a where a > 'x' order by b", Render(res))
+
+	res, ok = requote(r, tokens, find("b"))
+	assert.True(t, ok)
+	assert.Equal(t, "select a, `b` + 1 from t left join s on t.a = s.a where a > 'x' order by b", Render(res))
+	res, ok = requote(r, Tokenize("select `a`, `select`"), 2)
+	assert.True(t, ok)
+	assert.Equal(t, "select a, `select`", Render(res))
+	_, ok = requote(r, Tokenize("select `a`, `select`"), 5)
+	assert.False(t, ok)
+
+	for i := 0; i < 100; i++ {
+		res, ok = swapOperator(r, tokens, find(">"))
+		assert.True(t, ok)
+		op := Tokenize(Render(res))[find(">")]
+		assert.Contains(t, operatorClasses[0], op.Text)
+
+		res, ok = mutateLiteral(r, tokens, find("'x'"))
+		assert.True(t, ok)
+		str := Tokenize(Render(res))[find("'x'")]
+		assert.Equal(t, StringToken, str.Kind)
+	}
+	res, ok = mutateLiteral(r, Tokenize("select 'it''s'"), 2)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(Tokenize(Render(res))))
+}
+
+func TestTokenMutator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewTokenMutator(&TextMutator{})
+	m.Learn("create table `my table` (id int, name varchar(10))")
+	assert.Equal(t, []string{"my table", "id", "name"}, m.names.names)
+
+	tokens := Tokenize("select c from u")
+	for i := 0; i < 100; i++ {
+		res, ok := m.replaceIdent(r, tokens, 2)
+		assert.True(t, ok)
+		assert.Contains(t, []string{"select `my table` from u", "select id from u", "select name from u"}, Render(res))
+	}
+
+	// no applicable mutations falls back
+	assert.Contains(t, keywords, m.Mutate(r, ""))
+	for i := 0; i < 100; i++ {
+		assert.NotEqual(t, "", m.Mutate(r, "select c from u where c > 1"))
+	}
+}
@@ -15,6 +15,14 @@ func DirExists(path string) bool {
 	return s.IsDir()
 }
 
+func FileExists(path string) bool {
+	s, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !s.IsDir()
+}
+
 func Copy(src, dst string) error {
 	info, err := os.Lstat(src)
 	if err != nil {
@@ -0,0 +1,123 @@
+package tracer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Illyrix/tidb-go-fuzz/dep"
+	"github.com/Illyrix/tidb-go-fuzz/dep/types"
+)
+
+// Client fetches trace bits from the trace server started by
+// `__tidb_go_fuzz_dep.Listen()` in the instrumented tidb-server
+type Client struct {
+	Addr    string
+	Format  types.ReplyFormat
+	Timeout time.Duration
+}
+
+func NewClient(addr string) *Client {
+	if addr == "" {
+		addr = dep.ListenAddress
+	}
+	return &Client{
+		Addr:    addr,
+		Format:  types.ReplySparse, // most statements only hit a few edges
+		Timeout: 5 * time.Second,
+	}
+}
+
+// FetchBits returns the dense classified trace bits whatever format is
+// negotiated; the server resets its table when taking the snapshot
+func (c *Client) FetchBits() (*types.TraceBits, error) {
+	return c.fetch(types.CmdGetBits)
+}
+
+// FetchRawBits is like FetchBits but keeps the raw hit counts
+func (c *Client) FetchRawBits() (*types.TraceBits, error) {
+	return c.fetch(types.CmdGetRawBits)
+}
+
+// FetchRoute returns the latest block ids executed by the session running
+// the statement in order and how many blocks it executed since last fetch;
+// tidb must be built with -trace-route
+func (c *Client) FetchRoute() ([]types.TraceRouteType, uint64, error) {
+	conn, err := c.send(types.CmdGetRoute)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	return types.ReadRoute(conn)
+}
+
+// FetchCmpLog returns the latest traced comparisons in order and how many
+// comparisons are traced since last fetch; tidb must be built with -cmp-log
+func (c *Client) FetchCmpLog() ([]types.CmpRecord, uint64, error) {
+	conn, err := c.send(types.CmdGetCmpLog)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	return types.ReadCmpLog(conn)
+}
+
+// FetchPanics returns the first panics recovered by tidb and how many
+// panics are recovered since last fetch; tidb must be built with -recover-log
+func (c *Client) FetchPanics() ([]types.PanicRecord, uint64, error) {
+	conn, err := c.send(types.CmdGetPanics)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	return types.ReadPanics(conn)
+}
+
+// SetStatement tells tidb the statement going to be executed, it's written
+// into crash reports when tidb exits; tidb must be built with -crash-file
+func (c *Client) SetStatement(sql string) error {
+	conn, err := c.send(types.CmdSetStatement)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write(types.EncodeStatement(sql)); err != nil {
+		return err
+	}
+	// wait until it's set
+	ack := make([]byte, 1)
+	if _, err = io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+	if ack[0] != types.StatementAck {
+		return fmt.Errorf("unexpected ack %d of setting statement", ack[0])
+	}
+	return nil
+}
+
+func (c *Client) fetch(cmd types.Command) (*types.TraceBits, error) {
+	conn, err := c.send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return types.ReadReply(conn, c.Format)
+}
+
+func (c *Client) send(cmd types.Command) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	req := types.Request{Cmd: cmd, Format: c.Format}
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"errors"
+	"path/filepath"
 
 	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg"
 )
@@ -13,6 +14,35 @@ type Config struct {
 	TidbFromRemote bool   // using current master branch from github/tidb
 	TidbTargetDir  string // where we copy tidb source code to; should be empty
 
+	EnableMetrics bool   // register coverage metrics in the instrumented tidb
+	MetricsAddr   string // extra address serving coverage metrics; optional
+
+	BuildID              string // identifies this instrumented build
+	CoverageDumpPath     string // where tidb dumps coverage ever hit; empty to disable
+	CoverageDumpInterval int    // seconds between periodical dumps; 0 to disable
+
+	TraceRouteSize int  // how many latest blocks executed by each goroutine are recorded; 0 to disable
+	DynamicEdge    bool // compute edges from previous location at runtime like AFL
+
+	CallContextDepth int    // mix innermost N functions into edges; 0 to disable
+	CmpLogSize       int    // how many latest comparisons are traced; 0 to disable
+	RecoverLogSize   int    // how many recovered panics are kept per statement; 0 to disable
+	CrashFile        string // where reports of os.Exit and fatal logs are appended; empty to disable
+
+	Granularity     string // block, func or sample
+	GranularityPkgs string // per package granularity like `util/chunk=func,ddl=sample`
+	SampleRate      int    // 1 of N blocks is instrumented in sampled granularity
+
+	Profile        string  // pprof cpu profile of the uninstrumented tidb-server; optional
+	HotFraction    float64 // hottest functions taking this fraction of cpu time are excluded
+	HotGranularity string  // granularity of hot functions: skip, func or sample
+
+	ReachableRoots string // only functions reachable from these comma separated functions are instrumented; optional
+
+	InstrumentModules string // comma separated dependency modules copied into tidb and instrumented; optional
+
+	DictMin int // tokens found less than it in tidb source are not written into the dictionary
+
 	// todo: other fuzzer configures
 }
 
@@ -23,5 +53,15 @@ func (c *Config) Valid() error {
 	if pkg.DirExists(c.TidbTargetDir) {
 		return errors.New("target tidb code dir exists")
 	}
+	// tidb-server may not run in the directory where builder runs
+	if c.CoverageDumpPath != "" && !filepath.IsAbs(c.CoverageDumpPath) {
+		return errors.New("coverage dump path should be absolute")
+	}
+	if c.CrashFile != "" && !filepath.IsAbs(c.CrashFile) {
+		return errors.New("crash file path should be absolute")
+	}
+	if c.Profile != "" && (c.HotFraction <= 0 || c.HotFraction > 1) {
+		return errors.New("fraction of hot functions should be in (0, 1]")
+	}
 	return nil
 }
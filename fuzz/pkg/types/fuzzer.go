@@ -1,9 +1,50 @@
 package types
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Illyrix/tidb-go-fuzz/fuzz/pkg/dict"
+)
 
 type Fuzzer struct {
 	Ctx context.Context
 
 	// todo: some fuzzing context
 }
+
+type FuzzerConfig struct {
+	DSN       string // of the instrumented tidb-server, like `root@tcp(127.0.0.1:4000)/`
+	TraceAddr string // address of the trace server in tidb-server
+
+	Seeds   []string      // initial inputs; every input is one or more statements
+	Prepare string        // statements executed before every input to reset the state; optional
+	Timeout time.Duration // of executing an input, including prepare statements
+	Dict    []dict.Token  `json:"-"` // tokens inserted by mutators, like the dictionary written by the builder; optional
+
+	Grammar      string // parser.y, or a tidb checkout having it; statements are also generated from it if not empty
+	GrammarStart string // nonterminal the statements are derived from; `Statement` of tidb by default
+
+	Schedule      string        // power schedule of the queue: explore, fast, coe, exploit or rare; fast by default
+	Panics        bool          // fetch recovered panics; tidb must be built with -recover-log
+	StatsInterval time.Duration // between printing stats; 0 to disable
+	RandSeed      int64
+
+	CampaignDir string // keeps queue, crashes, hangs, coverage and stats; empty to keep them in memory
+	Resume      bool   // continue the campaign in CampaignDir
+	BuildID     string // of the instrumented tidb, printed by the builder; the saved coverage is reused only if it matches
+}
+
+func (c *FuzzerConfig) Valid() error {
+	if c.DSN == "" {
+		return errors.New("dsn of tidb is not assigned")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout should be positive")
+	}
+	if c.Resume && c.CampaignDir == "" {
+		return errors.New("campaign dir is not assigned to resume")
+	}
+	return nil
+}